@@ -0,0 +1,174 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Command reconnx-tune searches for reconnx machine configurations that
+work well against a recorded trace.
+
+The trace is a JSON Lines file of attempt records, as written by a
+reconnx.FileRecorder. Known bad time ranges ("incidents") are given
+either on the command line with the -bad flag, or in a file with one
+incident per line using the -labels flag. Each incident has the form
+START/END or START/END/HOST, where START and END are RFC 3339
+timestamps. An incident without a host applies to every host.
+
+For every combination of the candidate AbsThreshold, PctThreshold,
+ClosingStreak, ClosingCount and RestingCount values, reconnx-tune
+replays the trace through fresh machines and measures how quickly each
+incident is detected (the delay until the first connection is closed
+within it) and how many connections are closed unnecessarily outside
+any incident. It reports the configurations on the trade-off frontier
+between these two objectives.
+
+Usage:
+
+	reconnx-tune -trace trace.jsonl -bad 2021-09-23T10:00:00Z/2021-09-23T10:05:00Z \
+		-abs 500,1000,1500 -pct 0,50,100,200
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gogama/reconnx"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "reconnx-tune:", err)
+		os.Exit(2)
+	}
+}
+
+type incidentsFlag []incident
+
+func (f *incidentsFlag) String() string {
+	return fmt.Sprintf("%d incidents", len(*f))
+}
+
+func (f *incidentsFlag) Set(s string) error {
+	inc, err := parseIncident(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, inc)
+	return nil
+}
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("reconnx-tune", flag.ContinueOnError)
+	var (
+		tracePath  = fs.String("trace", "", "trace `file` in JSON Lines format (required)")
+		labelsPath = fs.String("labels", "", "`file` listing one incident per line")
+		historical = fs.Uint("historical", reconnx.DefaultHistoricalSamples, "number of historical samples")
+		recent     = fs.Uint("recent", reconnx.DefaultRecentSamples, "number of recent samples")
+		abs        = fs.String("abs", "0,500,1000,1500,2000", "candidate AbsThreshold `values` (ms)")
+		pct        = fs.String("pct", "0,50,100,200,400", "candidate PctThreshold `values` (percentage points)")
+		streak     = fs.String("streak", "1,3,5,10", "candidate ClosingStreak `values`")
+		count      = fs.String("count", "5,10,20,50", "candidate ClosingCount `values`")
+		rest       = fs.String("rest", "0,10,50,100", "candidate RestingCount `values`")
+		all        = fs.Bool("all", false, "report every configuration, not just the frontier")
+		incidents  incidentsFlag
+	)
+	fs.Var(&incidents, "bad", "known bad time range `START/END[/HOST]` (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *tracePath == "" {
+		return fmt.Errorf("missing -trace")
+	}
+	var recs []reconnx.Record
+	err := withFile(*tracePath, func(r io.Reader) (err error) {
+		recs, err = readTrace(r)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if *labelsPath != "" {
+		err = withFile(*labelsPath, func(r io.Reader) error {
+			labelled, err := readIncidents(r)
+			incidents = append(incidents, labelled...)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(incidents) == 0 {
+		return fmt.Errorf("no incidents: use -bad or -labels")
+	}
+
+	s := space{
+		historicalSamples: *historical,
+		recentSamples:     *recent,
+	}
+	if s.abs, err = parseFloats("abs", *abs); err != nil {
+		return err
+	}
+	if s.pct, err = parseFloats("pct", *pct); err != nil {
+		return err
+	}
+	if s.streak, err = parseUints("streak", *streak); err != nil {
+		return err
+	}
+	if s.count, err = parseUints("count", *count); err != nil {
+		return err
+	}
+	if s.rest, err = parseUints("rest", *rest); err != nil {
+		return err
+	}
+
+	configs := s.configs()
+	results := make([]result, len(configs))
+	for i := range configs {
+		results[i] = simulate(configs[i], recs, incidents)
+	}
+	if !*all {
+		results = frontier(results)
+	}
+
+	return report(stdout, results, len(incidents))
+}
+
+func withFile(path string, read func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return read(f)
+}
+
+func parseFloats(name, s string) ([]float64, error) {
+	var values []float64
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad -%s value %q", name, part)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func parseUints(name, s string) ([]uint, error) {
+	var values []uint
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(part), 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("bad -%s value %q", name, part)
+		}
+		values = append(values, uint(v))
+	}
+	return values, nil
+}
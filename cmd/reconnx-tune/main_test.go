@@ -0,0 +1,100 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTrace = `{"time":"2021-09-23T10:00:00Z","host":"foo","latency_ms":10}
+{"time":"2021-09-23T10:00:01Z","host":"foo","latency_ms":10}
+{"time":"2021-09-23T10:00:02Z","host":"foo","latency_ms":900}
+{"time":"2021-09-23T10:00:03Z","host":"foo","latency_ms":900}
+{"time":"2021-09-23T10:00:04Z","host":"foo","latency_ms":900}
+{"time":"2021-09-23T10:00:05Z","host":"foo","latency_ms":10}
+`
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconnx-tune")
+	require.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	tracePath := filepath.Join(dir, "trace.jsonl")
+	require.NoError(t, ioutil.WriteFile(tracePath, []byte(testTrace), 0644))
+	labelsPath := filepath.Join(dir, "labels.txt")
+	require.NoError(t, ioutil.WriteFile(labelsPath, []byte("2021-09-23T10:00:02Z/2021-09-23T10:00:04Z\n"), 0644))
+
+	t.Run("Frontier", func(t *testing.T) {
+		var b strings.Builder
+		err := run([]string{
+			"-trace", tracePath,
+			"-labels", labelsPath,
+			"-historical", "1",
+			"-recent", "1",
+			"-abs", "100,1000",
+			"-pct", "0",
+			"-streak", "2",
+			"-count", "1,2",
+			"-rest", "0",
+		}, &b)
+
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.Equal(t, []string{"100", "0", "2", "2", "0", "1/1", "1s", "0"}, strings.Fields(lines[1]))
+	})
+	t.Run("All", func(t *testing.T) {
+		var b strings.Builder
+		err := run([]string{
+			"-trace", tracePath,
+			"-bad", "2021-09-23T10:00:02Z/2021-09-23T10:00:04Z",
+			"-abs", "100,1000",
+			"-pct", "0",
+			"-streak", "1",
+			"-count", "1,2",
+			"-rest", "0",
+			"-all",
+		}, &b)
+
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		assert.Len(t, lines, 5)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			args []string
+			msg  string
+		}{
+			{"MissingTrace", []string{}, "missing -trace"},
+			{"NoIncidents", []string{"-trace", tracePath}, "no incidents: use -bad or -labels"},
+			{"BadTraceFile", []string{"-trace", filepath.Join(dir, "missing")}, ""},
+			{"BadLabelsFile", []string{"-trace", tracePath, "-labels", filepath.Join(dir, "missing")}, ""},
+			{"BadAbs", []string{"-trace", tracePath, "-labels", labelsPath, "-abs", "x"}, `bad -abs value "x"`},
+			{"BadPct", []string{"-trace", tracePath, "-labels", labelsPath, "-pct", "x"}, `bad -pct value "x"`},
+			{"BadStreak", []string{"-trace", tracePath, "-labels", labelsPath, "-streak", "-1"}, `bad -streak value "-1"`},
+			{"BadCount", []string{"-trace", tracePath, "-labels", labelsPath, "-count", "x"}, `bad -count value "x"`},
+			{"BadRest", []string{"-trace", tracePath, "-labels", labelsPath, "-rest", "x"}, `bad -rest value "x"`},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				err := run(testCase.args, ioutil.Discard)
+
+				require.Error(t, err)
+				if testCase.msg != "" {
+					assert.EqualError(t, err, testCase.msg)
+				}
+			})
+		}
+	})
+}
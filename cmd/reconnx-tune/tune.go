@@ -0,0 +1,257 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogama/reconnx"
+)
+
+// An incident is a labelled "known bad" time range. If host is empty,
+// the incident applies to every host in the trace.
+type incident struct {
+	start time.Time
+	end   time.Time
+	host  string
+}
+
+func (i incident) contains(rec *reconnx.Record) bool {
+	return (i.host == "" || i.host == rec.Host) && !rec.Time.Before(i.start) && !rec.Time.After(i.end)
+}
+
+func (i incident) duration() time.Duration {
+	return i.end.Sub(i.start)
+}
+
+// parseIncident parses an incident of the form "START/END" or
+// "START/END/HOST", where START and END are RFC 3339 timestamps.
+func parseIncident(s string) (incident, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 3)
+	if len(parts) < 2 {
+		return incident{}, fmt.Errorf("bad incident %q: expected START/END[/HOST]", s)
+	}
+	start, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return incident{}, fmt.Errorf("bad incident %q: %v", s, err)
+	}
+	end, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return incident{}, fmt.Errorf("bad incident %q: %v", s, err)
+	}
+	if end.Before(start) {
+		return incident{}, fmt.Errorf("bad incident %q: end before start", s)
+	}
+	inc := incident{start: start, end: end}
+	if len(parts) == 3 {
+		inc.host = parts[2]
+	}
+	return inc, nil
+}
+
+// readIncidents reads one incident per line. Blank lines and lines
+// starting with '#' are ignored.
+func readIncidents(r io.Reader) ([]incident, error) {
+	var incidents []incident
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		inc, err := parseIncident(line)
+		if err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, inc)
+	}
+	return incidents, s.Err()
+}
+
+// readTrace reads a trace in JSON Lines format, as written by the
+// reconnx.FileRecorder, and returns the records sorted by time.
+func readTrace(r io.Reader) ([]reconnx.Record, error) {
+	var recs []reconnx.Record
+	dec := json.NewDecoder(r)
+	for {
+		var rec reconnx.Record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("bad trace record %d: %v", len(recs)+1, err)
+		}
+		recs = append(recs, rec)
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].Time.Before(recs[j].Time)
+	})
+	return recs, nil
+}
+
+// A space lists the candidate values for each searched MachineConfig
+// field.
+type space struct {
+	historicalSamples uint
+	recentSamples     uint
+	abs               []float64
+	pct               []float64
+	streak            []uint
+	count             []uint
+	rest              []uint
+}
+
+func (s *space) configs() []reconnx.MachineConfig {
+	var configs []reconnx.MachineConfig
+	for _, abs := range s.abs {
+		for _, pct := range s.pct {
+			for _, streak := range s.streak {
+				for _, count := range s.count {
+					for _, rest := range s.rest {
+						configs = append(configs, reconnx.MachineConfig{
+							HistoricalSamples: s.historicalSamples,
+							RecentSamples:     s.recentSamples,
+							AbsThreshold:      abs,
+							PctThreshold:      pct,
+							ClosingStreak:     streak,
+							ClosingCount:      count,
+							RestingCount:      rest,
+						})
+					}
+				}
+			}
+		}
+	}
+	return configs
+}
+
+// A result measures how a MachineConfig performs against a trace.
+type result struct {
+	config reconnx.MachineConfig
+
+	// detected is the number of incidents during which at least one
+	// connection was closed.
+	detected int
+
+	// delay is the mean time from incident start to the first close
+	// within the incident. Undetected incidents count as the full
+	// incident duration.
+	delay time.Duration
+
+	// unnecessary is the number of connections closed outside any
+	// incident.
+	unnecessary int
+}
+
+// dominates reports whether r is at least as good as other on both
+// objectives, and strictly better on at least one.
+func (r *result) dominates(other *result) bool {
+	return r.delay <= other.delay && r.unnecessary <= other.unnecessary &&
+		(r.delay < other.delay || r.unnecessary < other.unnecessary)
+}
+
+// simulate replays the trace through one Machine per host, configured
+// with config, and measures the resulting close decisions against the
+// incidents.
+//
+// As in the reconnx plugin, a connection is closed whenever a host's
+// Machine is in the Closing state at the start of an attempt.
+func simulate(config reconnx.MachineConfig, recs []reconnx.Record, incidents []incident) result {
+	machines := map[string]reconnx.Machine{}
+	firstClose := make([]time.Time, len(incidents))
+	res := result{config: config}
+
+	for i := range recs {
+		rec := &recs[i]
+		m := machines[rec.Host]
+		if m == nil {
+			m = reconnx.NewMachine(config)
+			machines[rec.Host] = m
+		}
+		closed := m.State() == reconnx.Closing
+		m.Next(rec.Latency, closed)
+		if !closed {
+			continue
+		}
+
+		necessary := false
+		for j := range incidents {
+			if incidents[j].contains(rec) {
+				necessary = true
+				if firstClose[j].IsZero() {
+					firstClose[j] = rec.Time
+				}
+			}
+		}
+		if !necessary {
+			res.unnecessary++
+		}
+	}
+
+	var total time.Duration
+	for j := range incidents {
+		if firstClose[j].IsZero() {
+			total += incidents[j].duration()
+		} else {
+			res.detected++
+			total += firstClose[j].Sub(incidents[j].start)
+		}
+	}
+	if len(incidents) > 0 {
+		res.delay = total / time.Duration(len(incidents))
+	}
+
+	return res
+}
+
+// frontier returns the results not dominated by any other result,
+// ordered by increasing number of unnecessary closes.
+func frontier(results []result) []result {
+	var front []result
+	for i := range results {
+		dominated := false
+		for j := range results {
+			if i != j && results[j].dominates(&results[i]) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			front = append(front, results[i])
+		}
+	}
+	sort.SliceStable(front, func(i, j int) bool {
+		if front[i].unnecessary != front[j].unnecessary {
+			return front[i].unnecessary < front[j].unnecessary
+		}
+		return front[i].delay < front[j].delay
+	})
+	return front
+}
+
+func report(w io.Writer, results []result, incidents int) error {
+	_, err := fmt.Fprintf(w, "%-10s %-10s %-7s %-7s %-7s %-10s %-12s %s\n",
+		"ABS", "PCT", "STREAK", "COUNT", "REST", "DETECTED", "DELAY", "UNNECESSARY")
+	if err != nil {
+		return err
+	}
+	for i := range results {
+		r := &results[i]
+		_, err = fmt.Fprintf(w, "%-10g %-10g %-7d %-7d %-7d %-10s %-12s %d\n",
+			r.config.AbsThreshold, r.config.PctThreshold,
+			r.config.ClosingStreak, r.config.ClosingCount, r.config.RestingCount,
+			fmt.Sprintf("%d/%d", r.detected, incidents), r.delay, r.unnecessary)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
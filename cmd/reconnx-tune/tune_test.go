@@ -0,0 +1,177 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogama/reconnx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2021, 9, 23, 10, 0, 0, 0, time.UTC)
+
+func TestParseIncident(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		inc, err := parseIncident("2021-09-23T10:00:00Z/2021-09-23T10:05:00Z")
+		require.NoError(t, err)
+		assert.Equal(t, incident{start: t0, end: t0.Add(5 * time.Minute)}, inc)
+
+		inc, err = parseIncident(" 2021-09-23T10:00:00Z/2021-09-23T10:05:00Z/foo.com ")
+		require.NoError(t, err)
+		assert.Equal(t, incident{start: t0, end: t0.Add(5 * time.Minute), host: "foo.com"}, inc)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			"2021-09-23T10:00:00Z",
+			"yesterday/2021-09-23T10:05:00Z",
+			"2021-09-23T10:00:00Z/tomorrow",
+			"2021-09-23T10:05:00Z/2021-09-23T10:00:00Z",
+		} {
+			_, err := parseIncident(s)
+			assert.Error(t, err, s)
+		}
+	})
+}
+
+func TestReadIncidents(t *testing.T) {
+	incidents, err := readIncidents(strings.NewReader(`
+# comment
+2021-09-23T10:00:00Z/2021-09-23T10:05:00Z
+
+2021-09-23T11:00:00Z/2021-09-23T11:05:00Z/bar.org
+`))
+
+	require.NoError(t, err)
+	assert.Equal(t, []incident{
+		{start: t0, end: t0.Add(5 * time.Minute)},
+		{start: t0.Add(time.Hour), end: t0.Add(time.Hour + 5*time.Minute), host: "bar.org"},
+	}, incidents)
+
+	_, err = readIncidents(strings.NewReader("bad"))
+	assert.Error(t, err)
+}
+
+func TestReadTrace(t *testing.T) {
+	recs, err := readTrace(strings.NewReader(`{"time":"2021-09-23T10:00:02Z","host":"b","latency_ms":2}
+{"time":"2021-09-23T10:00:01Z","host":"a","latency_ms":1,"prev":"Closing","next":"Resting"}
+`))
+
+	require.NoError(t, err)
+	assert.Equal(t, []reconnx.Record{
+		{Time: t0.Add(time.Second), Host: "a", Latency: 1, Prev: reconnx.Closing, Next: reconnx.Resting},
+		{Time: t0.Add(2 * time.Second), Host: "b", Latency: 2},
+	}, recs)
+
+	_, err = readTrace(strings.NewReader(`{"time":"2021-09-23T10:00:02Z"}{`))
+	assert.EqualError(t, err, "bad trace record 2: unexpected EOF")
+}
+
+func TestSpace_Configs(t *testing.T) {
+	s := space{
+		historicalSamples: 7,
+		recentSamples:     2,
+		abs:               []float64{100, 200},
+		pct:               []float64{50},
+		streak:            []uint{1, 2},
+		count:             []uint{3},
+		rest:              []uint{0, 4},
+	}
+
+	configs := s.configs()
+
+	require.Len(t, configs, 8)
+	assert.Equal(t, reconnx.MachineConfig{
+		HistoricalSamples: 7,
+		RecentSamples:     2,
+		AbsThreshold:      100,
+		PctThreshold:      50,
+		ClosingStreak:     1,
+		ClosingCount:      3,
+		RestingCount:      0,
+	}, configs[0])
+	assert.Equal(t, reconnx.MachineConfig{
+		HistoricalSamples: 7,
+		RecentSamples:     2,
+		AbsThreshold:      200,
+		PctThreshold:      50,
+		ClosingStreak:     2,
+		ClosingCount:      3,
+		RestingCount:      4,
+	}, configs[7])
+}
+
+func TestSimulate(t *testing.T) {
+	var recs []reconnx.Record
+	latencies := []float64{10, 10, 10, 100, 100, 100, 100, 10, 10, 10, 100, 10, 10, 10}
+	for i, latency := range latencies {
+		recs = append(recs, reconnx.Record{
+			Time:    t0.Add(time.Duration(i) * time.Second),
+			Host:    "foo",
+			Latency: latency,
+		})
+	}
+	incidents := []incident{
+		{start: t0.Add(3 * time.Second), end: t0.Add(6 * time.Second)},
+		{start: t0.Add(time.Hour), end: t0.Add(time.Hour + time.Minute)},
+		{start: t0.Add(3 * time.Second), end: t0.Add(6 * time.Second), host: "bar"},
+	}
+	config := reconnx.MachineConfig{
+		HistoricalSamples: 1,
+		RecentSamples:     1,
+		AbsThreshold:      50,
+		ClosingStreak:     2,
+		ClosingCount:      2,
+	}
+
+	res := simulate(config, recs, incidents)
+
+	assert.Equal(t, config, res.config)
+	assert.Equal(t, 1, res.detected)
+	assert.Equal(t, (time.Second+time.Minute+3*time.Second)/3, res.delay)
+	assert.Equal(t, 4, res.unnecessary)
+}
+
+func TestFrontier(t *testing.T) {
+	results := []result{
+		{config: reconnx.MachineConfig{AbsThreshold: 1}, delay: 10, unnecessary: 5},
+		{config: reconnx.MachineConfig{AbsThreshold: 2}, delay: 20, unnecessary: 5},
+		{config: reconnx.MachineConfig{AbsThreshold: 3}, delay: 30, unnecessary: 1},
+		{config: reconnx.MachineConfig{AbsThreshold: 4}, delay: 5, unnecessary: 9},
+		{config: reconnx.MachineConfig{AbsThreshold: 5}, delay: 30, unnecessary: 1},
+	}
+
+	front := frontier(results)
+
+	assert.Equal(t, []result{results[2], results[4], results[0], results[3]}, front)
+}
+
+func TestReport(t *testing.T) {
+	var b strings.Builder
+	err := report(&b, []result{
+		{
+			config: reconnx.MachineConfig{
+				AbsThreshold:  1000,
+				PctThreshold:  50,
+				ClosingStreak: 3,
+				ClosingCount:  10,
+				RestingCount:  20,
+			},
+			detected:    1,
+			delay:       1500 * time.Millisecond,
+			unnecessary: 7,
+		},
+	}, 2)
+
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"ABS", "PCT", "STREAK", "COUNT", "REST", "DETECTED", "DELAY", "UNNECESSARY"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"1000", "50", "3", "10", "20", "1/2", "1.5s", "7"}, strings.Fields(lines[1]))
+}
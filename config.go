@@ -0,0 +1,205 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// UnmarshalJSON implements the json.Unmarshaler interface, so that a
+// Config can be loaded from a configuration file.
+//
+// Only the fields which describe the plugin's behavior can be decoded.
+// Fields holding objects, such as Logger, Pool and DNS.Resolver, are
+// left untouched, as are fields which are absent from the JSON, so they
+// can be set before or after decoding. Field names are matched
+// case-insensitively, and unknown fields are an error. Durations are
+// given as strings in the format accepted by time.ParseDuration, such
+// as "90s" or "10m".
+//
+// In addition to the fields of Config, the JSON may contain a Profiles
+// object which names MachineConfigs for reuse. Latency and each entry
+// of HostLatency may then be either the name of a profile, or an
+// object with an optional Profile field naming the profile it is based
+// on, and any MachineConfig fields overriding the profile. An object
+// without a Profile field is based on the existing Latency, or for
+// HostLatency entries, on the decoded Latency. For example:
+//
+//	{
+//	  "Profiles": {
+//	    "fast": {"AbsThreshold": 50, "ClosingStreak": 5, "ClosingCount": 20},
+//	    "slow": {"AbsThreshold": 5000, "ClosingStreak": 2, "ClosingCount": 5}
+//	  },
+//	  "Latency": "fast",
+//	  "HostLatency": {
+//	    ".reports.example.com": "slow",
+//	    "cache.example.com": {"Profile": "fast", "AbsThreshold": 20}
+//	  },
+//	  "Recycle": {"MaxAge": "10m", "Jitter": 0.1}
+//	}
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Profiles      map[string]MachineConfig
+		Latency       json.RawMessage
+		HostLatency   map[string]json.RawMessage
+		Include       *[]string
+		Exclude       *[]string
+		HeaderLatency *bool
+		PerConnection *bool
+		Strategy      *Strategy
+		Strategies    map[string]Strategy
+		Recycle       *RecycleConfig
+		DNS           *DNSConfig
+		Outliers      *OutlierConfig
+		MaxHosts      *int
+		HostIdleTTL   *duration
+		HistorySize   *int
+	}
+	aux.Include = &c.Include
+	aux.Exclude = &c.Exclude
+	aux.HeaderLatency = &c.HeaderLatency
+	aux.PerConnection = &c.PerConnection
+	aux.Strategy = &c.Strategy
+	aux.Recycle = &c.Recycle
+	aux.DNS = &c.DNS
+	aux.Outliers = &c.Outliers
+	aux.MaxHosts = &c.MaxHosts
+	aux.HostIdleTTL = (*duration)(&c.HostIdleTTL)
+	aux.HistorySize = &c.HistorySize
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.Latency != nil {
+		mc, err := decodeMachineConfig(aux.Latency, aux.Profiles, c.Latency)
+		if err != nil {
+			return fmt.Errorf("reconnx: Latency: %v", err)
+		}
+		c.Latency = mc
+	}
+	if aux.HostLatency != nil {
+		c.HostLatency = make(map[string]MachineConfig, len(aux.HostLatency))
+		for pattern, raw := range aux.HostLatency {
+			mc, err := decodeMachineConfig(raw, aux.Profiles, c.Latency)
+			if err != nil {
+				return fmt.Errorf("reconnx: HostLatency[%q]: %v", pattern, err)
+			}
+			c.HostLatency[pattern] = mc
+		}
+	}
+	if aux.Strategies != nil {
+		c.Strategies = aux.Strategies
+	}
+	return nil
+}
+
+// decodeMachineConfig decodes a MachineConfig which is either the name
+// of a profile, or an object based on the profile named by its Profile
+// field, or on base if it has none.
+func decodeMachineConfig(data []byte, profiles map[string]MachineConfig, base MachineConfig) (MachineConfig, error) {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return base, nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		return profile(profiles, name)
+	}
+
+	var ref struct {
+		Profile string
+	}
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return MachineConfig{}, err
+	}
+	if ref.Profile != "" {
+		var err error
+		if base, err = profile(profiles, ref.Profile); err != nil {
+			return MachineConfig{}, err
+		}
+	}
+	aux := struct {
+		Profile string
+		MachineConfig
+	}{MachineConfig: base}
+	if err := decodeStrict(data, &aux); err != nil {
+		return MachineConfig{}, err
+	}
+	return aux.MachineConfig, nil
+}
+
+func profile(profiles map[string]MachineConfig, name string) (MachineConfig, error) {
+	mc, ok := profiles[name]
+	if !ok {
+		return MachineConfig{}, fmt.Errorf("unknown profile %q", name)
+	}
+	return mc, nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. MaxAge is
+// given as a string in the format accepted by time.ParseDuration.
+func (rc *RecycleConfig) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		MaxAge      duration
+		MaxRequests uint
+		Jitter      float64
+	}{duration(rc.MaxAge), rc.MaxRequests, rc.Jitter}
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	rc.MaxAge, rc.MaxRequests, rc.Jitter = time.Duration(aux.MaxAge), aux.MaxRequests, aux.Jitter
+	return nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. Interval
+// and Timeout are given as strings in the format accepted by
+// time.ParseDuration. Resolver is left untouched.
+func (dc *DNSConfig) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		Interval duration
+		Timeout  duration
+	}{duration(dc.Interval), duration(dc.Timeout)}
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	dc.Interval, dc.Timeout = time.Duration(aux.Interval), time.Duration(aux.Timeout)
+	return nil
+}
+
+// A duration is a time.Duration which is decoded from JSON as a string
+// in the format accepted by time.ParseDuration, or as a number of
+// nanoseconds.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err = json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("reconnx: invalid duration %s", data)
+		}
+		*d = duration(n)
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("reconnx: invalid duration %q", s)
+	}
+	*d = duration(v)
+	return nil
+}
+
+// decodeStrict decodes a JSON value into v, rejecting unknown fields.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_UnmarshalJSON(t *testing.T) {
+	t.Run("Everything", func(t *testing.T) {
+		l := &NopLogger{}
+		r := newMockResolver(t)
+		c := Config{Logger: l, DNS: DNSConfig{Resolver: r}}
+
+		err := json.Unmarshal([]byte(`{
+			"Profiles": {
+				"fast": {"AbsThreshold": 50, "ClosingStreak": 5, "ClosingCount": 20},
+				"slow": {"AbsThreshold": 5000, "ClosingStreak": 2, "ClosingCount": 5}
+			},
+			"Latency": {"Profile": "fast", "RestingCount": 3},
+			"HostLatency": {
+				".reports.example.com": "slow",
+				"cache.example.com": {"AbsThreshold": 20}
+			},
+			"Include": [".example.com"],
+			"Exclude": ["stream.example.com", "10.0.0.0/8"],
+			"HeaderLatency": true,
+			"perConnection": true,
+			"Strategy": "Drain",
+			"Strategies": {"stream.example.com": "Close"},
+			"Recycle": {"MaxAge": "10m", "MaxRequests": 100, "Jitter": 0.1},
+			"DNS": {"Interval": "30s", "Timeout": 5000000000},
+			"Outliers": {"Ratio": 3, "MinPeers": 4},
+			"MaxHosts": 1000,
+			"HostIdleTTL": "1h",
+			"HistorySize": 8
+		}`), &c)
+
+		require.NoError(t, err)
+		fast := MachineConfig{AbsThreshold: 50.0, ClosingStreak: 5, ClosingCount: 20}
+		latency := fast
+		latency.RestingCount = 3
+		cache := latency
+		cache.AbsThreshold = 20.0
+		assert.Equal(t, Config{
+			Logger:  l,
+			Latency: latency,
+			HostLatency: map[string]MachineConfig{
+				".reports.example.com": {AbsThreshold: 5000.0, ClosingStreak: 2, ClosingCount: 5},
+				"cache.example.com":    cache,
+			},
+			Include:       []string{".example.com"},
+			Exclude:       []string{"stream.example.com", "10.0.0.0/8"},
+			HeaderLatency: true,
+			PerConnection: true,
+			Strategy:      StrategyDrain,
+			Strategies:    map[string]Strategy{"stream.example.com": StrategyClose},
+			Recycle:       RecycleConfig{MaxAge: 10 * time.Minute, MaxRequests: 100, Jitter: 0.1},
+			DNS:           DNSConfig{Interval: 30 * time.Second, Timeout: 5 * time.Second, Resolver: r},
+			Outliers:      OutlierConfig{Ratio: 3.0, MinPeers: 4},
+			MaxHosts:      1000,
+			HostIdleTTL:   time.Hour,
+			HistorySize:   8,
+		}, c)
+	})
+	t.Run("Partial", func(t *testing.T) {
+		c := Config{
+			Latency: MachineConfig{AbsThreshold: 100.0, ClosingStreak: 1},
+			Recycle: RecycleConfig{MaxAge: time.Minute, Jitter: 0.5},
+		}
+
+		err := json.Unmarshal([]byte(`{"Latency": {"ClosingCount": 2}, "Recycle": {"MaxRequests": 10}}`), &c)
+
+		require.NoError(t, err)
+		assert.Equal(t, Config{
+			Latency: MachineConfig{AbsThreshold: 100.0, ClosingStreak: 1, ClosingCount: 2},
+			Recycle: RecycleConfig{MaxAge: time.Minute, MaxRequests: 10, Jitter: 0.5},
+		}, c)
+	})
+	t.Run("Errors", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			json string
+			err  string
+		}{
+			{"UnknownField", `{"Latncy": {}}`, `json: unknown field "Latncy"`},
+			{"UnknownMachineField", `{"Latency": {"AbsThreshhold": 1}}`, `reconnx: Latency: json: unknown field "AbsThreshhold"`},
+			{"UnknownProfile", `{"Latency": "medium"}`, `reconnx: Latency: unknown profile "medium"`},
+			{"UnknownHostProfile", `{"HostLatency": {"foo": {"Profile": "medium"}}}`, `reconnx: HostLatency["foo"]: unknown profile "medium"`},
+			{"BadDuration", `{"Recycle": {"MaxAge": "ten minutes"}}`, `reconnx: invalid duration "ten minutes"`},
+			{"BadDurationType", `{"DNS": {"Interval": true}}`, `reconnx: invalid duration true`},
+			{"BadStrategy", `{"Strategy": "Explode"}`, `reconnx: invalid strategy "Explode"`},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				var c Config
+				err := json.Unmarshal([]byte(testCase.json), &c)
+				assert.EqualError(t, err, testCase.err)
+			})
+		}
+	})
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package debug provides an http.Handler which exposes the live state of
+every host tracked by an installed reconnx plugin.
+
+Mount the handler on an internal admin mux to see at a glance which
+hosts reconnx is closing connections to:
+
+	p := reconnx.Install(client, cfg)
+	adminMux.Handle("/debug/reconnx", debug.Handler(p))
+
+The handler renders simple HTML tables of the hosts and their recent
+history by default. It renders JSON if the request has the query
+parameter "format=json", or if it prefers JSON according to its Accept
+header.
+*/
+package debug
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogama/reconnx"
+)
+
+// A Host is the JSON representation of the state of one host.
+type Host struct {
+	Host           string         `json:"host"`
+	State          reconnx.State  `json:"state"`
+	Reason         reconnx.Reason `json:"reason"`
+	RecentAvg      float64        `json:"recent_avg"`
+	HistoricalAvg  float64        `json:"historical_avg"`
+	ClosedStreak   uint           `json:"closed_streak"`
+	ClosedCount    uint           `json:"closed_count"`
+	RestCount      uint           `json:"rest_count"`
+	Attempts       uint64         `json:"attempts"`
+	CloseRequests  uint64         `json:"close_requests"`
+	Transitions    uint64         `json:"transitions"`
+	LastTransition *time.Time     `json:"last_transition,omitempty"`
+	History        []Entry        `json:"history,omitempty"`
+}
+
+// An Entry is the JSON representation of one entry in the recent
+// history of a host, oldest first.
+type Entry struct {
+	Time          time.Time           `json:"time"`
+	Kind          reconnx.HistoryKind `json:"kind"`
+	Attempt       int                 `json:"attempt"`
+	Prev          reconnx.State       `json:"prev"`
+	Next          reconnx.State       `json:"next"`
+	Reason        reconnx.Reason      `json:"reason"`
+	RecentAvg     float64             `json:"recent_avg"`
+	HistoricalAvg float64             `json:"historical_avg"`
+	ClosedStreak  uint                `json:"closed_streak"`
+	ClosedCount   uint                `json:"closed_count"`
+}
+
+// A Page is the JSON representation of the handler's response.
+type Page struct {
+	Time  time.Time `json:"time"`
+	Hosts []Host    `json:"hosts"`
+}
+
+// Handler returns an http.Handler which renders the current state of
+// every host tracked by p, either as JSON or as an HTML table.
+func Handler(p *reconnx.Plugin) http.Handler {
+	if p == nil {
+		panic("reconnx/debug: nil plugin")
+	}
+
+	return &handler{p: p}
+}
+
+type handler struct {
+	p *reconnx.Plugin
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := newPage(h.p, time.Now())
+	w.Header().Set("Cache-Control", "no-store")
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(page)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = pageTemplate.Execute(w, page)
+}
+
+func newPage(p *reconnx.Plugin, now time.Time) Page {
+	snapshot := p.Snapshot()
+	page := Page{
+		Time:  now,
+		Hosts: make([]Host, len(snapshot)),
+	}
+	for i := range snapshot {
+		s := &snapshot[i]
+		page.Hosts[i] = Host{
+			Host:          s.Host,
+			State:         s.State,
+			Reason:        s.Reason,
+			RecentAvg:     s.RecentAvg,
+			HistoricalAvg: s.HistoricalAvg,
+			ClosedStreak:  s.ClosedStreak,
+			ClosedCount:   s.ClosedCount,
+			RestCount:     s.RestCount,
+			Attempts:      s.Attempts,
+			CloseRequests: s.CloseRequests,
+			Transitions:   s.Transitions,
+		}
+		if !s.LastTransition.IsZero() {
+			t := s.LastTransition
+			page.Hosts[i].LastTransition = &t
+		}
+		history, _ := p.History(s.Host)
+		for j := range history {
+			he := &history[j]
+			page.Hosts[i].History = append(page.Hosts[i].History, Entry{
+				Time:          he.Time,
+				Kind:          he.Kind,
+				Attempt:       he.Attempt,
+				Prev:          he.Prev,
+				Next:          he.Next,
+				Reason:        he.Reason,
+				RecentAvg:     he.RecentAvg,
+				HistoricalAvg: he.HistoricalAvg,
+				ClosedStreak:  he.ClosedStreak,
+				ClosedCount:   he.ClosedCount,
+			})
+		}
+	}
+	return page
+}
+
+func wantsJSON(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0])
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+
+	return false
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"avg": func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	},
+	"since": func(now time.Time, t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return now.Sub(*t).Truncate(time.Millisecond).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>reconnx</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+tr.Closing td { background: #fdd; }
+tr.Resting td { background: #ffd; }
+</style>
+</head>
+<body>
+<h1>reconnx</h1>
+<p>{{len .Hosts}} host(s) at {{.Time.Format "2006-01-02T15:04:05.000Z07:00"}}</p>
+<table>
+<tr><th>Host</th><th>State</th><th>Reason</th><th>Recent avg</th><th>Historical avg</th><th>Closed streak</th><th>Closed count</th><th>Rest count</th><th>Attempts</th><th>Close requests</th><th>Transitions</th><th>Last transition</th></tr>
+{{- $now := .Time}}
+{{- range .Hosts}}
+<tr class="{{.State}}"><td>{{.Host}}</td><td>{{.State}}</td><td>{{.Reason}}</td><td>{{avg .RecentAvg}}</td><td>{{avg .HistoricalAvg}}</td><td>{{.ClosedStreak}}</td><td>{{.ClosedCount}}</td><td>{{.RestCount}}</td><td>{{.Attempts}}</td><td>{{.CloseRequests}}</td><td>{{.Transitions}}</td><td>{{since $now .LastTransition}}</td></tr>
+{{- end}}
+</table>
+<h2>Recent history</h2>
+<table>
+<tr><th>Host</th><th>Time</th><th>Kind</th><th>Attempt</th><th>Prev</th><th>Next</th><th>Reason</th><th>Recent avg</th><th>Historical avg</th><th>Closed streak</th><th>Closed count</th></tr>
+{{- range .Hosts}}
+{{- $host := .Host}}
+{{- range .History}}
+<tr class="{{.Next}}"><td>{{$host}}</td><td>{{.Time.Format "15:04:05.000"}}</td><td>{{.Kind}}</td><td>{{.Attempt}}</td><td>{{.Prev}}</td><td>{{.Next}}</td><td>{{.Reason}}</td><td>{{avg .RecentAvg}}</td><td>{{avg .HistoricalAvg}}</td><td>{{.ClosedStreak}}</td><td>{{.ClosedCount}}</td></tr>
+{{- end}}
+{{- end}}
+</table>
+</body>
+</html>
+`))
@@ -0,0 +1,116 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gogama/httpx"
+	"github.com/gogama/reconnx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	t.Run("NilPlugin", func(t *testing.T) {
+		assert.PanicsWithValue(t, "reconnx/debug: nil plugin", func() {
+			Handler(nil)
+		})
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	cl := &httpx.Client{}
+	p := reconnx.Install(cl, reconnx.Config{
+		Latency: reconnx.MachineConfig{
+			AbsThreshold:  1.0,
+			ClosingStreak: 5,
+			ClosingCount:  5,
+		},
+	})
+	_, err = cl.Get(server.URL)
+	require.NoError(t, err)
+	h := Handler(p)
+
+	t.Run("JSON", func(t *testing.T) {
+		for _, r := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/?format=json", nil),
+			withAccept(httptest.NewRequest(http.MethodGet, "/", nil), "text/plain;q=0.5, application/json"),
+		} {
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+			var page Page
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+			require.Len(t, page.Hosts, 1)
+			host := page.Hosts[0]
+			assert.Equal(t, u.Host, host.Host)
+			assert.Equal(t, reconnx.Closing, host.State)
+			assert.Equal(t, uint64(1), host.Attempts)
+			assert.Equal(t, uint64(1), host.Transitions)
+			assert.NotNil(t, host.LastTransition)
+			assert.Equal(t, reconnx.ReasonAbsThreshold, host.Reason)
+			require.Len(t, host.History, 1)
+			assert.Equal(t, reconnx.HistoryTransition, host.History[0].Kind)
+			assert.Equal(t, reconnx.Watching, host.History[0].Prev)
+			assert.Equal(t, reconnx.Closing, host.History[0].Next)
+			assert.Equal(t, reconnx.ReasonAbsThreshold, host.History[0].Reason)
+		}
+	})
+	t.Run("HTML", func(t *testing.T) {
+		for _, r := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/", nil),
+			httptest.NewRequest(http.MethodGet, "/?format=html", nil),
+			withAccept(httptest.NewRequest(http.MethodGet, "/?format=", nil), "text/html, application/json"),
+		} {
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+			body := w.Body.String()
+			assert.Contains(t, body, "<td>"+u.Host+"</td><td>Closing</td><td>AbsThreshold</td>")
+			assert.Contains(t, body, "<td>Transition</td><td>0</td><td>Watching</td><td>Closing</td><td>AbsThreshold</td>")
+			assert.Contains(t, body, "1 host(s)")
+			assert.Contains(t, body, " ago</td>")
+		}
+	})
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+	})
+}
+
+func TestNewPage(t *testing.T) {
+	p := reconnx.InstallHandlers(&httpx.HandlerGroup{}, reconnx.Config{})
+	now := time.Now()
+
+	page := newPage(p, now)
+
+	assert.Equal(t, Page{Time: now, Hosts: []Host{}}, page)
+}
+
+func withAccept(r *http.Request, accept string) *http.Request {
+	r.Header.Set("Accept", accept)
+	return r
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import "github.com/gogama/httpx/request"
+
+type decisionKeyType int
+
+// DecisionKey is the key under which the reconnx plugin stores a
+// Decision value in each request execution it handles, using the
+// execution's SetValue method.
+//
+// Other event handlers, for example a logging handler installed at
+// httpx.AfterExecutionEnd, can retrieve the Decision for the most
+// recent attempt either with the DecisionOf function or directly:
+//
+//	d, ok := e.Value(reconnx.DecisionKey).(reconnx.Decision)
+var DecisionKey = new(decisionKeyType)
+
+// A Decision describes what the reconnx plugin decided to do about one
+// request attempt, and why.
+//
+// The plugin stores the Decision for an attempt in the execution when
+// the attempt starts, and updates it with the measured sample when the
+// attempt ends. It is replaced when the next attempt starts.
+type Decision struct {
+	// Host is the host key identifying the Machine consulted for the
+	// attempt.
+	Host string
+
+	// Attempt is the zero-based attempt number within the execution.
+	Attempt int
+
+	// State is the host Machine's state when the attempt started.
+	State State
+
+	// Close indicates whether the plugin requested that the attempt's
+	// connection be closed after the attempt ends.
+	Close bool
+
+	// Recycled indicates whether the connection was closed because it
+	// exceeded a limit in the Config's RecycleConfig, because its
+	// address disappeared from DNS, or because its peer was ejected as
+	// an outlier, rather than because of latency. Because the connection
+	// is only known once the attempt is under way, Recycled is only
+	// valid if Done is true.
+	Recycled bool
+
+	// Drain indicates whether the plugin drained the host's connections
+	// after the attempt, because the host's Strategy is StrategyDrain,
+	// rather than closing the attempt's connection. It is only set if
+	// Close is also set.
+	Drain bool
+
+	// Trigger explains why the Machine which caused the close, either
+	// the host's or, in per-connection mode, the connection's, was in
+	// the Closing state. If no Machine caused a close, but the attempt
+	// ended in an error, after which the transport normally discards the
+	// attempt's connection, Trigger is ReasonError once Done is true.
+	// Otherwise it is ReasonNone, as it is if the Machine does not
+	// report its statistics.
+	Trigger Reason
+
+	// Conn identifies the connection the attempt was sent on by its
+	// local and remote addresses. It is only set if the plugin tracks
+	// connections, which it does in per-connection mode and when
+	// recycling connections, watching DNS, detecting outliers, or
+	// quarantining peers, and only once the connection is known.
+	Conn string
+
+	// HTTP2 indicates whether the attempt's response was received over
+	// HTTP/2. When the plugin closes an HTTP/2 connection and the Config
+	// has a Pool, it also retires the host's connections in the Pool. It
+	// is only valid if Done is true.
+	HTTP2 bool
+
+	// Done indicates whether the attempt has ended. The Sample and
+	// ErrorClass fields are only valid if Done is true.
+	Done bool
+
+	// Sample is the attempt latency in milliseconds that was pushed
+	// into the host Machine when the attempt ended.
+	Sample float64
+
+	// ErrorClass classifies the attempt error, if any, in the same way
+	// as the ErrorClass field of a Record. It is empty if the attempt
+	// did not end in error.
+	ErrorClass string
+}
+
+// DecisionOf returns the Decision the reconnx plugin stored in the
+// execution for the most recent attempt. The second return value is
+// false if no Decision is stored, for example because the plugin is not
+// installed or the execution has not yet made an attempt.
+func DecisionOf(e *request.Execution) (Decision, bool) {
+	d, ok := e.Value(DecisionKey).(Decision)
+	return d, ok
+}
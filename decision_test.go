@@ -0,0 +1,30 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"testing"
+
+	"github.com/gogama/httpx/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionOf(t *testing.T) {
+	t.Run("Missing", func(t *testing.T) {
+		_, ok := DecisionOf(&request.Execution{})
+
+		assert.False(t, ok)
+	})
+	t.Run("Present", func(t *testing.T) {
+		e := &request.Execution{}
+		d := Decision{Host: "foo", Attempt: 2, State: Closing, Close: true, Trigger: ReasonAbsThreshold}
+		e.SetValue(DecisionKey, d)
+
+		d2, ok := DecisionOf(e)
+
+		assert.True(t, ok)
+		assert.Equal(t, d, d2)
+	})
+}
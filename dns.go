@@ -0,0 +1,169 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Resolver looks up the addresses of a host name. The standard
+// library *net.Resolver implements Resolver.
+type Resolver interface {
+	// LookupHost looks up the given host and returns a slice of its
+	// addresses.
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// A DNSConfig specifies how the reconnx plugin watches for DNS changes.
+//
+// When DNS watching is enabled, the plugin periodically re-resolves the
+// host name of every server it has seen a connection to. If an address
+// disappears from the DNS answer for a host name, the connections to
+// the host name whose remote IP address is the removed address are
+// recycled the next time they are used, in the same way as connections
+// which exceed a limit in the RecycleConfig. Connections to the host
+// name's other addresses are not affected.
+//
+// Only addresses which were in an earlier answer count as removed, so
+// connections whose remote IP address was never in the DNS answer for
+// their host name are left alone. This is the case for connections
+// which go through an HTTP proxy, whose remote IP address is that of
+// the proxy.
+//
+// DNS watching runs on a background goroutine which is stopped by the
+// Plugin's Close method.
+type DNSConfig struct {
+	// Interval is the time between re-resolutions. If Interval is zero
+	// or negative, DNS watching is disabled.
+	Interval time.Duration
+
+	// Timeout is the maximum time allowed for each lookup. If Timeout
+	// is zero or negative, Interval is used.
+	Timeout time.Duration
+
+	// Resolver is used to look up host names. If nil, the standard
+	// library net.DefaultResolver is used.
+	Resolver Resolver
+}
+
+func (dc DNSConfig) enabled() bool {
+	return dc.Interval > 0
+}
+
+// A dnsWatcher periodically re-resolves the host names of the tracked
+// connections and marks the connections whose peers are gone.
+type dnsWatcher struct {
+	h       *handler
+	config  DNSConfig
+	answers map[string][]string
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newDNSWatcher(h *handler, config DNSConfig) *dnsWatcher {
+	if config.Timeout <= 0 {
+		config.Timeout = config.Interval
+	}
+	if config.Resolver == nil {
+		config.Resolver = net.DefaultResolver
+	}
+
+	return &dnsWatcher{
+		h:       h,
+		config:  config,
+		answers: map[string][]string{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *dnsWatcher) start() {
+	go w.run()
+}
+
+func (w *dnsWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check re-resolves every tracked host name once, and forgets the
+// answers for host names which are no longer tracked.
+func (w *dnsWatcher) check() {
+	hostnames := w.h.conns.hostnames()
+	tracked := make(map[string]bool, len(hostnames))
+	for _, hostname := range hostnames {
+		tracked[hostname] = true
+	}
+	for hostname := range w.answers {
+		if !tracked[hostname] {
+			delete(w.answers, hostname)
+		}
+	}
+
+	for _, hostname := range hostnames {
+		ctx, cancel := context.WithTimeout(context.Background(), w.config.Timeout)
+		addrs, err := w.config.Resolver.LookupHost(ctx, hostname)
+		cancel()
+		if err != nil {
+			logMessage(w.h, LevelWarn, "DNS lookup failed",
+				[]Field{{FieldHost, hostname}, {FieldReason, err.Error()}},
+				"reconnx: DNS lookup for %s failed: %s", hostname, err)
+			continue
+		}
+
+		sort.Strings(addrs)
+		prev, ok := w.answers[hostname]
+		w.answers[hostname] = addrs
+		answer, prevAnswer := strings.Join(addrs, ","), strings.Join(prev, ",")
+		if !ok || prevAnswer == answer {
+			continue
+		}
+		logMessage(w.h, LevelInfo, "DNS answer changed",
+			[]Field{{FieldHost, hostname}, {FieldReason, answer}},
+			"reconnx: DNS answer for %s changed from [%s] to [%s]", hostname, prevAnswer, answer)
+
+		for _, poolHost := range w.h.conns.markStale(hostname, removedAddrs(prev, addrs)) {
+			logMessage(w.h, LevelInfo, "connections to removed addresses will be recycled",
+				[]Field{{FieldHost, poolHost}},
+				"reconnx: connections to %s whose address is no longer in DNS will be recycled", poolHost)
+		}
+	}
+}
+
+// removedAddrs returns the addresses in the sorted slice prev which are
+// not in the sorted slice addrs.
+func removedAddrs(prev, addrs []string) []string {
+	var removed []string
+	for _, addr := range prev {
+		i := sort.SearchStrings(addrs, addr)
+		if i == len(addrs) || addrs[i] != addr {
+			removed = append(removed, addr)
+		}
+	}
+	return removed
+}
+
+func (w *dnsWatcher) close() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+}
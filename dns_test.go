@@ -0,0 +1,189 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSConfig_enabled(t *testing.T) {
+	assert.False(t, DNSConfig{}.enabled())
+	assert.False(t, DNSConfig{Interval: -1, Timeout: time.Second}.enabled())
+	assert.True(t, DNSConfig{Interval: time.Second}.enabled())
+}
+
+func TestNewDNSWatcher(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		w := newDNSWatcher(&handler{}, DNSConfig{Interval: time.Minute})
+
+		assert.Equal(t, time.Minute, w.config.Timeout)
+		assert.Same(t, net.DefaultResolver, w.config.Resolver)
+	})
+	t.Run("Explicit", func(t *testing.T) {
+		r := newMockResolver(t)
+
+		w := newDNSWatcher(&handler{}, DNSConfig{Interval: time.Minute, Timeout: time.Second, Resolver: r})
+
+		assert.Equal(t, time.Second, w.config.Timeout)
+		assert.Same(t, r, w.config.Resolver)
+	})
+}
+
+func TestDNSWatcher(t *testing.T) {
+	now := time.Now()
+	t.Run("Check", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		var msgs []string
+		l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).
+			Run(func(args mock.Arguments) {
+				var msg string
+				renderPrintf(&msg)(args)
+				msgs = append(msgs, msg)
+			})
+		h.conns = newConnTracker(RecycleConfig{})
+		h.conns.use("a", connPeer{poolHost: "foo:443", ip: "10.0.0.1"}, false, now)
+		h.conns.use("b", connPeer{poolHost: "foo:443", ip: "10.0.0.2"}, false, now)
+		h.conns.use("c", connPeer{poolHost: "bar", ip: "10.0.0.3"}, false, now)
+		h.conns.use("d", connPeer{poolHost: "10.0.0.4:80", ip: "10.0.0.4"}, false, now)
+		h.conns.use("e", connPeer{poolHost: "foo:443", ip: "10.0.0.9"}, false, now)
+		r := newMockResolver(t)
+		r.On("LookupHost", "bar").Return([]string{"10.0.0.3"}, nil).Twice()
+		r.On("LookupHost", "foo").Return([]string{"10.0.0.2", "10.0.0.1"}, nil).Once()
+		r.On("LookupHost", "foo").Return([]string{"10.0.0.5", "10.0.0.2"}, nil).Once()
+		w := newDNSWatcher(h, DNSConfig{Interval: time.Minute, Resolver: r})
+
+		w.check()
+		w.check()
+
+		r.AssertExpectations(t)
+		assert.Equal(t, []string{
+			"reconnx: DNS answer for foo changed from [10.0.0.1,10.0.0.2] to [10.0.0.2,10.0.0.5]",
+			"reconnx: connections to foo:443 whose address is no longer in DNS will be recycled",
+		}, msgs)
+		assert.Equal(t, recycleDNS, h.conns.use("a", connPeer{}, true, now))
+		assert.Equal(t, recycleNone, h.conns.use("b", connPeer{}, true, now))
+		assert.Equal(t, recycleNone, h.conns.use("c", connPeer{}, true, now))
+		assert.Equal(t, recycleNone, h.conns.use("e", connPeer{}, true, now))
+	})
+	t.Run("LookupError", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		var msg string
+		l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).
+			Run(renderPrintf(&msg)).
+			Once()
+		h.conns = newConnTracker(RecycleConfig{})
+		h.conns.use("a", connPeer{poolHost: "foo", ip: "10.0.0.1"}, false, now)
+		r := newMockResolver(t)
+		r.On("LookupHost", "foo").Return([]string(nil), errors.New("no such host")).Once()
+		w := newDNSWatcher(h, DNSConfig{Interval: time.Minute, Resolver: r})
+
+		w.check()
+
+		l.AssertExpectations(t)
+		r.AssertExpectations(t)
+		assert.Equal(t, "reconnx: DNS lookup for foo failed: no such host", msg)
+		assert.Equal(t, recycleNone, h.conns.use("a", connPeer{}, true, now))
+	})
+	t.Run("Prune", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		h.conns = newConnTracker(RecycleConfig{})
+		h.conns.use("a", connPeer{poolHost: "foo", ip: "10.0.0.1"}, false, now)
+		h.conns.use("b", connPeer{poolHost: "bar", ip: "10.0.0.2"}, false, now)
+		r := newMockResolver(t)
+		r.On("LookupHost", "foo").Return([]string{"10.0.0.1"}, nil).Once()
+		r.On("LookupHost", "bar").Return([]string{"10.0.0.2"}, nil).Twice()
+		w := newDNSWatcher(h, DNSConfig{Interval: time.Minute, Resolver: r})
+
+		w.check()
+		h.conns.forget("a")
+		w.check()
+
+		l.AssertExpectations(t)
+		r.AssertExpectations(t)
+		assert.Equal(t, map[string][]string{"bar": {"10.0.0.2"}}, w.answers)
+	})
+}
+
+func TestDNS(t *testing.T) {
+	server, closed := newConnTrackingServer(t)
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+	_, port, err := net.SplitHostPort(serverAddr)
+	require.NoError(t, err)
+	base := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, serverAddr)
+		},
+	}
+	pool := NewPool(base)
+	r := &fakeResolver{addrs: []string{"127.0.0.1"}}
+	tr := NewTransport(nil, Config{
+		Pool: pool,
+		DNS: DNSConfig{
+			Interval: time.Millisecond,
+			Resolver: r,
+		},
+	})
+	p := tr.Plugin()
+	defer p.Close()
+	cl := &http.Client{Transport: tr}
+	u := "http://backend.test:" + port + "/"
+
+	get(t, cl, u)
+	time.Sleep(10 * time.Millisecond)
+	get(t, cl, u)
+	require.Equal(t, int32(0), atomic.LoadInt32(closed))
+	r.set([]string{"10.0.0.1"})
+
+	assert.Eventually(t, func() bool {
+		get(t, cl, u)
+		return atomic.LoadInt32(closed) == 1
+	}, time.Second, time.Millisecond)
+	p.Close()
+	p.Close()
+}
+
+type mockResolver struct {
+	mock.Mock
+}
+
+func newMockResolver(t *testing.T) *mockResolver {
+	m := &mockResolver{}
+	m.Test(t)
+	return m
+}
+
+func (m *mockResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	args := m.Called(host)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+type fakeResolver struct {
+	lock  sync.Mutex
+	addrs []string
+}
+
+func (r *fakeResolver) set(addrs []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.addrs = addrs
+}
+
+func (r *fakeResolver) LookupHost(context.Context, string) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.addrs...), nil
+}
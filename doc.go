@@ -21,6 +21,44 @@ Use the OnClient function to install reconnx in any httpx.Client:
 	reconnx.OnClient(cl, cfg)       // Install reconnx plugin
 
 If you need to install reconnx directly onto an httpx.HandlerGroup, use
-the OnHandlers function.
+the OnHandlers function. To use reconnx with a plain net/http client,
+wrap the client's transport with NewTransport.
+
+A misconfigured plugin fails silently, for example by never closing a
+connection. Use the Validate method of Config to check a configuration,
+or install the plugin with TryInstall or TryInstallHandlers, which
+return an error instead of installing an invalid configuration.
+
+A Config can also be loaded from a JSON document, since it implements
+json.Unmarshaler, from YAML using the separately versioned
+github.com/gogama/reconnx/yamlconfig module, or from environment
+variables using the FromEnv function.
+
+To see what reconnx currently thinks of each host, install the plugin
+with the Install or InstallHandlers function instead. These return a
+Plugin handle whose Hosts, Stats and Snapshot methods report the state
+of every tracked host.
+
+Each request execution handled by reconnx carries a Decision describing
+what the plugin decided about the most recent attempt, and why. Other
+event handlers can retrieve it with the DecisionOf function.
+
+By default, reconnx recycles connections to a slow host one at a time,
+as each is picked for a new request attempt. To also drop the host's
+idle connections as soon as it starts closing connections, send the
+client's requests through a Pool and set it in the Config.
+
+For long-polling and streaming clients, whose requests should not be
+cut off, select StrategyDrain in the Config. The plugin then drains a
+slow host's connections through the Pool, letting the requests in
+flight on them finish before closing them.
+
+To make sure the replacement for a closed connection goes to a
+different server, dial the client's connections with a Quarantine and
+set it in the Config.
+
+To leave some hosts alone, such as localhost, streaming endpoints or
+metadata services, list them in the Exclude field of the Config, or
+list the only hosts reconnx should manage in its Include field.
 */
 package reconnx
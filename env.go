@@ -0,0 +1,166 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FromEnv loads a Config from environment variables whose names start
+// with prefix followed by an underscore. For example, with the prefix
+// "MYAPP_RECONNX", the absolute latency threshold is read from the
+// variable MYAPP_RECONNX_ABS_THRESHOLD.
+//
+// If the variable named prefix_CONFIG is set, it is decoded first as a
+// JSON document in the format described by Config's UnmarshalJSON
+// method. The following variables, if set, then override the
+// corresponding fields:
+//
+//	ABS_THRESHOLD                 Latency.AbsThreshold
+//	PCT_THRESHOLD                 Latency.PctThreshold
+//	HISTORICAL_SAMPLES            Latency.HistoricalSamples
+//	RECENT_SAMPLES                Latency.RecentSamples
+//	CLOSING_STREAK                Latency.ClosingStreak
+//	CLOSING_COUNT                 Latency.ClosingCount
+//	RESTING_COUNT                 Latency.RestingCount
+//	INCLUDE                       Include
+//	EXCLUDE                       Exclude
+//	HEADER_LATENCY                HeaderLatency
+//	PER_CONNECTION                PerConnection
+//	STRATEGY                      Strategy
+//	RECYCLE_MAX_AGE               Recycle.MaxAge
+//	RECYCLE_MAX_REQUESTS          Recycle.MaxRequests
+//	RECYCLE_JITTER                Recycle.Jitter
+//	DNS_INTERVAL                  DNS.Interval
+//	DNS_TIMEOUT                   DNS.Timeout
+//	OUTLIER_RATIO                 Outliers.Ratio
+//	OUTLIER_SAMPLES               Outliers.Samples
+//	OUTLIER_MIN_PEERS             Outliers.MinPeers
+//	OUTLIER_MAX_EJECTED_FRACTION  Outliers.MaxEjectedFraction
+//	MAX_HOSTS                     MaxHosts
+//	HOST_IDLE_TTL                 HostIdleTTL
+//	HISTORY_SIZE                  HistorySize
+//
+// Include and Exclude are lists of host patterns separated by commas.
+// Durations are in the format accepted by time.ParseDuration, booleans
+// in the format accepted by strconv.ParseBool, and strategies are named
+// as by Strategy's String method.
+//
+// If any variable cannot be parsed, FromEnv returns a *ConfigError
+// describing every such variable. FromEnv does not validate the loaded
+// Config. Use its Validate method, or install it with TryInstall.
+func FromEnv(prefix string) (Config, error) {
+	return fromEnv(prefix, os.LookupEnv)
+}
+
+func fromEnv(prefix string, lookup func(string) (string, bool)) (Config, error) {
+	var c Config
+	if prefix != "" {
+		prefix += "_"
+	}
+	if v, ok := lookup(prefix + "CONFIG"); ok {
+		if err := json.Unmarshal([]byte(v), &c); err != nil {
+			return Config{}, &ConfigError{Problems: []string{fmt.Sprintf("%sCONFIG: %v", prefix, err)}}
+		}
+	}
+
+	vars := []struct {
+		name  string
+		parse func(string) error
+	}{
+		{"ABS_THRESHOLD", parseFloat(&c.Latency.AbsThreshold)},
+		{"PCT_THRESHOLD", parseFloat(&c.Latency.PctThreshold)},
+		{"HISTORICAL_SAMPLES", parseUint(&c.Latency.HistoricalSamples)},
+		{"RECENT_SAMPLES", parseUint(&c.Latency.RecentSamples)},
+		{"CLOSING_STREAK", parseUint(&c.Latency.ClosingStreak)},
+		{"CLOSING_COUNT", parseUint(&c.Latency.ClosingCount)},
+		{"RESTING_COUNT", parseUint(&c.Latency.RestingCount)},
+		{"INCLUDE", parseList(&c.Include)},
+		{"EXCLUDE", parseList(&c.Exclude)},
+		{"HEADER_LATENCY", parseBool(&c.HeaderLatency)},
+		{"PER_CONNECTION", parseBool(&c.PerConnection)},
+		{"STRATEGY", func(v string) error { return c.Strategy.UnmarshalText([]byte(v)) }},
+		{"RECYCLE_MAX_AGE", parseDuration(&c.Recycle.MaxAge)},
+		{"RECYCLE_MAX_REQUESTS", parseUint(&c.Recycle.MaxRequests)},
+		{"RECYCLE_JITTER", parseFloat(&c.Recycle.Jitter)},
+		{"DNS_INTERVAL", parseDuration(&c.DNS.Interval)},
+		{"DNS_TIMEOUT", parseDuration(&c.DNS.Timeout)},
+		{"OUTLIER_RATIO", parseFloat(&c.Outliers.Ratio)},
+		{"OUTLIER_SAMPLES", parseUint(&c.Outliers.Samples)},
+		{"OUTLIER_MIN_PEERS", parseInt(&c.Outliers.MinPeers)},
+		{"OUTLIER_MAX_EJECTED_FRACTION", parseFloat(&c.Outliers.MaxEjectedFraction)},
+		{"MAX_HOSTS", parseInt(&c.MaxHosts)},
+		{"HOST_IDLE_TTL", parseDuration(&c.HostIdleTTL)},
+		{"HISTORY_SIZE", parseInt(&c.HistorySize)},
+	}
+	var problems []string
+	for _, ev := range vars {
+		v, ok := lookup(prefix + ev.name)
+		if !ok {
+			continue
+		}
+		if err := ev.parse(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s%s: invalid value %q", prefix, ev.name, v))
+		}
+	}
+	if err := problemsError(problems); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+func parseFloat(p *float64) func(string) error {
+	return func(v string) (err error) {
+		*p, err = strconv.ParseFloat(v, 64)
+		return
+	}
+}
+
+func parseUint(p *uint) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 0)
+		*p = uint(n)
+		return err
+	}
+}
+
+func parseInt(p *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 0)
+		*p = int(n)
+		return err
+	}
+}
+
+func parseBool(p *bool) func(string) error {
+	return func(v string) (err error) {
+		*p, err = strconv.ParseBool(v)
+		return
+	}
+}
+
+func parseList(p *[]string) func(string) error {
+	return func(v string) error {
+		*p = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+		return nil
+	}
+}
+
+func parseDuration(p *time.Duration) func(string) error {
+	return func(v string) (err error) {
+		*p, err = time.ParseDuration(v)
+		return
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		c, err := FromEnv("RECONNX_TEST_UNSET")
+
+		require.NoError(t, err)
+		assert.Equal(t, Config{}, c)
+	})
+	t.Run("Environment", func(t *testing.T) {
+		require.NoError(t, os.Setenv("RECONNX_TEST_ABS_THRESHOLD", "1500"))
+		defer func() { _ = os.Unsetenv("RECONNX_TEST_ABS_THRESHOLD") }()
+
+		c, err := FromEnv("RECONNX_TEST")
+
+		require.NoError(t, err)
+		assert.Equal(t, 1500.0, c.Latency.AbsThreshold)
+	})
+	t.Run("Everything", func(t *testing.T) {
+		env := map[string]string{
+			"CONFIG":                       `{"Latency": {"AbsThreshold": 1, "PctThreshold": 2}, "HostLatency": {"foo": {}}}`,
+			"ABS_THRESHOLD":                "1500",
+			"HISTORICAL_SAMPLES":           "100",
+			"RECENT_SAMPLES":               "10",
+			"CLOSING_STREAK":               "5",
+			"CLOSING_COUNT":                "20",
+			"RESTING_COUNT":                "50",
+			"INCLUDE":                      ".example.com",
+			"EXCLUDE":                      " localhost, 127.0.0.0/8 ,",
+			"HEADER_LATENCY":               "1",
+			"PER_CONNECTION":               "true",
+			"STRATEGY":                     "Drain",
+			"RECYCLE_MAX_AGE":              "10m",
+			"RECYCLE_MAX_REQUESTS":         "1000",
+			"RECYCLE_JITTER":               "0.2",
+			"DNS_INTERVAL":                 "30s",
+			"DNS_TIMEOUT":                  "5s",
+			"OUTLIER_RATIO":                "3",
+			"OUTLIER_SAMPLES":              "8",
+			"OUTLIER_MIN_PEERS":            "4",
+			"OUTLIER_MAX_EJECTED_FRACTION": "0.25",
+			"MAX_HOSTS":                    "1000",
+			"HOST_IDLE_TTL":                "1h",
+			"HISTORY_SIZE":                 "-1",
+		}
+
+		c, err := fromEnv("", lookupIn(env))
+
+		require.NoError(t, err)
+		assert.Equal(t, Config{
+			Latency: MachineConfig{
+				HistoricalSamples: 100,
+				RecentSamples:     10,
+				AbsThreshold:      1500.0,
+				PctThreshold:      2.0,
+				ClosingStreak:     5,
+				ClosingCount:      20,
+				RestingCount:      50,
+			},
+			HostLatency:   map[string]MachineConfig{"foo": {AbsThreshold: 1.0, PctThreshold: 2.0}},
+			Include:       []string{".example.com"},
+			Exclude:       []string{"localhost", "127.0.0.0/8"},
+			HeaderLatency: true,
+			PerConnection: true,
+			Strategy:      StrategyDrain,
+			Recycle:       RecycleConfig{MaxAge: 10 * time.Minute, MaxRequests: 1000, Jitter: 0.2},
+			DNS:           DNSConfig{Interval: 30 * time.Second, Timeout: 5 * time.Second},
+			Outliers:      OutlierConfig{Ratio: 3.0, Samples: 8, MinPeers: 4, MaxEjectedFraction: 0.25},
+			MaxHosts:      1000,
+			HostIdleTTL:   time.Hour,
+			HistorySize:   -1,
+		}, c)
+	})
+	t.Run("InvalidConfig", func(t *testing.T) {
+		_, err := fromEnv("X", lookupIn(map[string]string{"X_CONFIG": "{"}))
+
+		assertProblems(t, err, "X_CONFIG: unexpected end of JSON input")
+	})
+	t.Run("InvalidValues", func(t *testing.T) {
+		_, err := fromEnv("X", lookupIn(map[string]string{
+			"X_ABS_THRESHOLD":   "fast",
+			"X_CLOSING_STREAK":  "-1",
+			"X_PER_CONNECTION":  "maybe",
+			"X_STRATEGY":        "Explode",
+			"X_RECYCLE_MAX_AGE": "10",
+			"X_HISTORY_SIZE":    "1.5",
+		}))
+
+		assertProblems(t, err,
+			`X_ABS_THRESHOLD: invalid value "fast"`,
+			`X_CLOSING_STREAK: invalid value "-1"`,
+			`X_PER_CONNECTION: invalid value "maybe"`,
+			`X_STRATEGY: invalid value "Explode"`,
+			`X_RECYCLE_MAX_AGE: invalid value "10"`,
+			`X_HISTORY_SIZE: invalid value "1.5"`)
+	})
+}
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultFileRecorderBufferSize is the default number of records
+	// a FileRecorder buffers in memory if the BufferSize field of a
+	// FileRecorderConfig is zero.
+	DefaultFileRecorderBufferSize = 1024
+
+	emptyFileRecorderPathMsg = "reconnx: empty file recorder path"
+)
+
+// A FileRecorderConfig specifies how to configure a new FileRecorder.
+type FileRecorderConfig struct {
+	// Path is the name of the file records are written to. Path must
+	// not be empty.
+	Path string
+
+	// MaxBytes is the size in bytes at which the file is rotated. If
+	// MaxBytes is zero or negative, the file is never rotated.
+	MaxBytes int64
+
+	// MaxBackups is the number of rotated files to keep. Rotated files
+	// are named by appending ".1", ".2", and so on to Path, with ".1"
+	// being the most recent. If MaxBackups is zero, the file is simply
+	// truncated when it is rotated.
+	MaxBackups int
+
+	// BufferSize is the number of records that can be buffered in
+	// memory waiting to be written. If zero,
+	// DefaultFileRecorderBufferSize is used.
+	BufferSize int
+}
+
+// A FileRecorder is a Recorder which writes records to a file in JSON
+// Lines format, one JSON object per line, rotating the file when it
+// reaches a configured size.
+//
+// FileRecorder never blocks the request path. Records are buffered in
+// memory and written to the file by a background goroutine. If the
+// buffer is full, new records are dropped and counted. Use the Dropped
+// method to find out how many records were dropped.
+type FileRecorder struct {
+	dropped uint64 // Accessed atomically, keep first for alignment.
+	config  FileRecorderConfig
+	records chan Record
+	done    chan struct{}
+	file    *os.File
+	w       *bufio.Writer
+	size    int64
+	err     error
+	closed  bool
+	lock    sync.RWMutex
+}
+
+// NewFileRecorder opens the file named in the configuration, appending
+// to it if it already exists, and returns a FileRecorder that writes
+// to it. The caller must call Close when the FileRecorder is no longer
+// needed.
+func NewFileRecorder(config FileRecorderConfig) (*FileRecorder, error) {
+	if config.Path == "" {
+		return nil, errors.New(emptyFileRecorderPathMsg)
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultFileRecorderBufferSize
+	}
+
+	fr := &FileRecorder{
+		config:  config,
+		records: make(chan Record, config.BufferSize),
+		done:    make(chan struct{}),
+	}
+	if err := fr.open(); err != nil {
+		return nil, err
+	}
+
+	go fr.run()
+
+	return fr, nil
+}
+
+// Record queues a record to be written to the file. If the buffer is
+// full, or the FileRecorder is closed, the record is dropped.
+func (fr *FileRecorder) Record(rec Record) {
+	fr.lock.RLock()
+	defer fr.lock.RUnlock()
+
+	if fr.closed {
+		atomic.AddUint64(&fr.dropped, 1)
+		return
+	}
+
+	select {
+	case fr.records <- rec:
+	default:
+		atomic.AddUint64(&fr.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records which were dropped, either
+// because the buffer was full or because they could not be written to
+// the file.
+func (fr *FileRecorder) Dropped() uint64 {
+	return atomic.LoadUint64(&fr.dropped)
+}
+
+// Close writes any buffered records to the file and closes it. It
+// returns the first error encountered while writing, if any.
+func (fr *FileRecorder) Close() error {
+	fr.lock.Lock()
+	if fr.closed {
+		fr.lock.Unlock()
+		return nil
+	}
+	fr.closed = true
+	close(fr.records)
+	fr.lock.Unlock()
+
+	<-fr.done
+
+	return fr.err
+}
+
+func (fr *FileRecorder) run() {
+	defer close(fr.done)
+	defer fr.closeFile()
+
+	for rec := range fr.records {
+		fr.write(rec)
+		if len(fr.records) == 0 && fr.w != nil {
+			fr.setErr(fr.w.Flush())
+		}
+	}
+}
+
+func (fr *FileRecorder) write(rec Record) {
+	if fr.w == nil {
+		atomic.AddUint64(&fr.dropped, 1)
+		return
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		atomic.AddUint64(&fr.dropped, 1)
+		fr.setErr(err)
+		return
+	}
+	b = append(b, '\n')
+
+	if fr.config.MaxBytes > 0 && fr.size > 0 && fr.size+int64(len(b)) > fr.config.MaxBytes {
+		if err = fr.rotate(); err != nil {
+			atomic.AddUint64(&fr.dropped, 1)
+			fr.setErr(err)
+			return
+		}
+	}
+
+	n, err := fr.w.Write(b)
+	fr.size += int64(n)
+	if err != nil {
+		atomic.AddUint64(&fr.dropped, 1)
+		fr.setErr(err)
+	}
+}
+
+func (fr *FileRecorder) open() error {
+	f, err := os.OpenFile(fr.config.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	fr.file = f
+	fr.w = bufio.NewWriter(f)
+	fr.size = info.Size()
+	return nil
+}
+
+func (fr *FileRecorder) rotate() error {
+	if err := fr.closeFile(); err != nil {
+		return err
+	}
+
+	path := fr.config.Path
+	if fr.config.MaxBackups > 0 {
+		for i := fr.config.MaxBackups - 1; i > 0; i-- {
+			err := os.Rename(backupPath(path, i), backupPath(path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(path, backupPath(path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(path, 0); err != nil {
+		return err
+	}
+
+	return fr.open()
+}
+
+func (fr *FileRecorder) closeFile() error {
+	if fr.file == nil {
+		return nil
+	}
+
+	err := fr.w.Flush()
+	if err2 := fr.file.Close(); err == nil {
+		err = err2
+	}
+	fr.file, fr.w, fr.size = nil, nil, 0
+	fr.setErr(err)
+	return err
+}
+
+func (fr *FileRecorder) setErr(err error) {
+	if fr.err == nil {
+		fr.err = err
+	}
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFileRecorder(t *testing.T) {
+	t.Run("EmptyPath", func(t *testing.T) {
+		fr, err := NewFileRecorder(FileRecorderConfig{})
+
+		assert.Nil(t, fr)
+		assert.EqualError(t, err, emptyFileRecorderPathMsg)
+	})
+	t.Run("BadPath", func(t *testing.T) {
+		dir := tempDir(t)
+		fr, err := NewFileRecorder(FileRecorderConfig{
+			Path: filepath.Join(dir, "missing", "trace.jsonl"),
+		})
+
+		assert.Nil(t, fr)
+		assert.Error(t, err)
+	})
+	t.Run("DefaultBufferSize", func(t *testing.T) {
+		dir := tempDir(t)
+		fr, err := NewFileRecorder(FileRecorderConfig{
+			Path: filepath.Join(dir, "trace.jsonl"),
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, DefaultFileRecorderBufferSize, cap(fr.records))
+		assert.NoError(t, fr.Close())
+	})
+}
+
+func TestFileRecorder(t *testing.T) {
+	t.Run("Write", func(t *testing.T) {
+		dir := tempDir(t)
+		path := filepath.Join(dir, "trace.jsonl")
+		fr, err := NewFileRecorder(FileRecorderConfig{Path: path})
+		require.NoError(t, err)
+
+		fr.Record(Record{Host: "foo", Attempt: 0, Next: Closing})
+		fr.Record(Record{Host: "bar", Attempt: 1, Prev: Closing, Next: Resting})
+		require.NoError(t, fr.Close())
+
+		recs := readRecords(t, path)
+		assert.Equal(t, []Record{
+			{Host: "foo", Attempt: 0, Next: Closing},
+			{Host: "bar", Attempt: 1, Prev: Closing, Next: Resting},
+		}, recs)
+		assert.Equal(t, uint64(0), fr.Dropped())
+	})
+	t.Run("Rotate", func(t *testing.T) {
+		for _, maxBackups := range []int{0, 2} {
+			dir := tempDir(t)
+			path := filepath.Join(dir, "trace.jsonl")
+			fr, err := NewFileRecorder(FileRecorderConfig{
+				Path:       path,
+				MaxBytes:   1,
+				MaxBackups: maxBackups,
+			})
+			require.NoError(t, err)
+
+			fr.Record(Record{Host: "a"})
+			fr.Record(Record{Host: "b"})
+			fr.Record(Record{Host: "c"})
+			fr.Record(Record{Host: "d"})
+			require.NoError(t, fr.Close())
+
+			assert.Equal(t, []Record{{Host: "d"}}, readRecords(t, path))
+			if maxBackups > 0 {
+				assert.Equal(t, []Record{{Host: "c"}}, readRecords(t, path+".1"))
+				assert.Equal(t, []Record{{Host: "b"}}, readRecords(t, path+".2"))
+				assert.NoFileExists(t, path+".3")
+			} else {
+				assert.NoFileExists(t, path+".1")
+			}
+		}
+	})
+	t.Run("DropWhenFull", func(t *testing.T) {
+		fr := &FileRecorder{
+			records: make(chan Record, 1),
+		}
+
+		fr.Record(Record{Host: "foo"})
+		fr.Record(Record{Host: "bar"})
+
+		assert.Equal(t, uint64(1), fr.Dropped())
+		assert.Equal(t, Record{Host: "foo"}, <-fr.records)
+	})
+	t.Run("DropWhenClosed", func(t *testing.T) {
+		dir := tempDir(t)
+		fr, err := NewFileRecorder(FileRecorderConfig{
+			Path: filepath.Join(dir, "trace.jsonl"),
+		})
+		require.NoError(t, err)
+		require.NoError(t, fr.Close())
+
+		fr.Record(Record{Host: "foo"})
+
+		assert.Equal(t, uint64(1), fr.Dropped())
+		assert.NoError(t, fr.Close())
+	})
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "reconnx")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func readRecords(t *testing.T, path string) []Record {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var recs []Record
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var rec Record
+		require.NoError(t, json.Unmarshal(s.Bytes(), &rec))
+		recs = append(recs, rec)
+	}
+	require.NoError(t, s.Err())
+	return recs
+}
@@ -5,6 +5,10 @@
 package reconnx
 
 import (
+	"container/list"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,8 +18,242 @@ import (
 
 type handler struct {
 	Config
-	hostLatency     map[string]Machine
+	hostLatency     map[string]*hostEntry
 	hostLatencyLock sync.RWMutex
+	hostOrder       *list.List
+	counters        counters
+	conns           *connTracker
+	dns             *dnsWatcher
+	outliers        *outlierDetector
+	filter          *hostFilter
+}
+
+// counters holds the plugin-wide counters exposed through the Plugin's
+// Counters method.
+type counters struct {
+	lock          sync.Mutex
+	attempts      uint64
+	closeRequests uint64
+	errors        uint64
+	evictions     uint64
+	transitions   map[StateChange]uint64
+}
+
+func (c *counters) requestedClose() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closeRequests++
+}
+
+func (c *counters) observed(prev, next State) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.attempts++
+	if prev != next {
+		if c.transitions == nil {
+			c.transitions = map[StateChange]uint64{}
+		}
+		c.transitions[StateChange{From: prev, To: next}]++
+	}
+}
+
+func (c *counters) erred() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.errors++
+}
+
+func (c *counters) evicted() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.evictions++
+}
+
+func (c *counters) snapshot() Counters {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	transitions := make(map[StateChange]uint64, len(c.transitions))
+	for sc, n := range c.transitions {
+		transitions[sc] = n
+	}
+	return Counters{
+		Attempts:      c.attempts,
+		CloseRequests: c.closeRequests,
+		Errors:        c.errors,
+		Evictions:     c.evictions,
+		Transitions:   transitions,
+	}
+}
+
+// A hostEntry holds the latency state machine for one host, together
+// with the bookkeeping exposed through the Plugin's introspection
+// methods.
+type hostEntry struct {
+	Machine
+	lock           sync.Mutex
+	attempts       uint64
+	closeRequests  uint64
+	transitions    uint64
+	lastTransition time.Time
+	history        history
+	poolHost       string
+
+	// drainGen counts the times the host's Machine has left the Closing
+	// state, so that a drain which completes late is not credited to a
+	// later Closing period. drainStart is when the current Closing
+	// period's drain started, or zero if none has started, drained is
+	// whether it has completed, and drainFallback is whether the plugin
+	// has given up on draining and closes connections instead.
+	drainGen      uint64
+	drainStart    time.Time
+	drained       bool
+	drainFallback bool
+
+	// elem is the host's element in the handler's hostOrder list, and
+	// lastUsed is when the host was last looked up for an attempt. They
+	// are protected by the handler's hostLatencyLock.
+	elem     *list.Element
+	lastUsed time.Time
+}
+
+func newHostEntry(sm Machine, historySize int) *hostEntry {
+	return &hostEntry{
+		Machine: sm,
+		history: newHistory(historySize),
+	}
+}
+
+func (he *hostEntry) requestedClose(attempt int, kind HistoryKind, state State, stats MachineStats, t time.Time) {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	he.closeRequests++
+	he.history.push(HistoryEntry{
+		Time:          t,
+		Kind:          kind,
+		Attempt:       attempt,
+		Prev:          state,
+		Next:          state,
+		Reason:        stats.Reason,
+		RecentAvg:     stats.RecentAvg,
+		HistoricalAvg: stats.HistoricalAvg,
+		ClosedStreak:  stats.ClosedStreak,
+		ClosedCount:   stats.ClosedCount,
+	})
+}
+
+func (he *hostEntry) observed(attempt int, tr Transition, t time.Time) {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	he.attempts++
+	if tr.Prev != tr.Next {
+		he.transitions++
+		he.lastTransition = t
+		he.history.push(HistoryEntry{
+			Time:          t,
+			Kind:          HistoryTransition,
+			Attempt:       attempt,
+			Prev:          tr.Prev,
+			Next:          tr.Next,
+			Reason:        tr.Reason,
+			RecentAvg:     tr.RecentAvg,
+			HistoricalAvg: tr.HistoricalAvg,
+			ClosedStreak:  tr.ClosedStreak,
+			ClosedCount:   tr.ClosedCount,
+		})
+	}
+}
+
+// usedPool records the Host of the URL of the host's most recent
+// attempt, which identifies the host's connection pool in the Pool.
+func (he *hostEntry) usedPool(poolHost string) {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	he.poolHost = poolHost
+}
+
+// beginDrain records that a drain of the host's connections starts,
+// returning false if one has already started in the current Closing
+// period, or if the plugin has fallen back to closing connections. The
+// returned generation identifies the Closing period.
+func (he *hostEntry) beginDrain(t time.Time) (uint64, bool) {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	if !he.drainStart.IsZero() || he.drainFallback {
+		return 0, false
+	}
+	he.drainStart = t
+	return he.drainGen, true
+}
+
+// drainDone records that the drain started in the Closing period
+// identified by gen completed.
+func (he *hostEntry) drainDone(gen uint64) {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	if gen == he.drainGen {
+		he.drained = true
+	}
+}
+
+// isDrained reports whether the current Closing period's drain has
+// completed.
+func (he *hostEntry) isDrained() bool {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	return he.drained
+}
+
+// failDrain makes the plugin close the host's connections instead of
+// draining them for the rest of the current Closing period.
+func (he *hostEntry) failDrain() {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	he.drainFallback = true
+}
+
+// fallBack reports whether the plugin closes the host's connections
+// instead of draining them, which it does once the current drain has
+// taken longer than drainTimeout. The second return value is true if
+// the drain timed out just now.
+func (he *hostEntry) fallBack(t time.Time) (fallback bool, timedOut bool) {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	if !he.drainFallback && !he.drained && !he.drainStart.IsZero() && t.Sub(he.drainStart) >= drainTimeout {
+		he.drainFallback = true
+		timedOut = true
+	}
+	return he.drainFallback, timedOut
+}
+
+// resetDrain forgets the drain of the Closing period which the host's
+// Machine just left.
+func (he *hostEntry) resetDrain() {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	he.drainGen++
+	he.drainStart = time.Time{}
+	he.drained = false
+	he.drainFallback = false
+}
+
+func (he *hostEntry) recentHistory() []HistoryEntry {
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	return he.history.slice()
+}
+
+func (he *hostEntry) stats(host string) HostStats {
+	ms := machineStats(he.Machine, he.State())
+	he.lock.Lock()
+	defer he.lock.Unlock()
+	return HostStats{
+		Host:           host,
+		MachineStats:   ms,
+		Attempts:       he.attempts,
+		CloseRequests:  he.closeRequests,
+		Transitions:    he.transitions,
+		LastTransition: he.lastTransition,
+	}
 }
 
 func (h *handler) Handle(evt httpx.Event, e *request.Execution) {
@@ -35,8 +273,38 @@ type executionStateKeyType int
 
 var executionStateKey = new(executionStateKeyType)
 
+// An executionState holds the state of each attempt of a request
+// execution. Each attemptState is allocated separately, since a racing
+// attempt's connection trace may still write to it from the transport's
+// goroutine while later attempts are being appended.
 type executionState struct {
-	attemptStart []time.Time
+	attempts []*attemptState
+}
+
+type attemptState struct {
+	start    time.Time
+	entry    *hostEntry
+	state    State
+	close    bool
+	trigger  Reason
+	recycled bool
+	drain    bool
+	conn     string
+	peer     connPeer
+}
+
+// decision returns the Decision describing the attempt.
+func (as *attemptState) decision(host string, attempt int) Decision {
+	return Decision{
+		Host:     host,
+		Attempt:  attempt,
+		State:    as.state,
+		Close:    as.close || as.recycled,
+		Trigger:  as.trigger,
+		Recycled: as.recycled,
+		Drain:    as.drain,
+		Conn:     as.conn,
+	}
 }
 
 func beforeExecutionStart(e *request.Execution) {
@@ -56,28 +324,38 @@ func beforeAttempt(h *handler, e *request.Execution) {
 	// Get the request.
 	r := e.Request
 	if r == nil {
-		h.Logger.Printf(missingExecutionRequestMsg)
+		reportError(h, host, e.Attempt, missingExecutionRequestMsg)
 		return
 	}
 
 	// Record the attempt start time.
-	es := getExecutionState(h, e)
+	es := getExecutionState(h, e, host)
 	if es == nil {
 		return
 	}
-	if len(es.attemptStart) != e.Attempt {
-		h.Logger.Printf("reconnx: ERROR: unexpected attempt start (%d)", e.Attempt)
+	if len(es.attempts) != e.Attempt {
+		reportError(h, host, e.Attempt, "reconnx: ERROR: unexpected attempt start (%d)", e.Attempt)
 		return
 	}
-	es.attemptStart = append(es.attemptStart, time.Now())
+	as := &attemptState{start: time.Now()}
+	es.attempts = append(es.attempts, as)
 
 	// Check the state machine for this host to see if it the connection
 	// should be closed when the attempt finishes.
-	sm := getOrCreateHostLatencyStateMachine(h, host)
-	if sm.State() == Closing {
-		h.Logger.Printf("reconnx: a connection to %s will be closed after attempt %d ends", host, e.Attempt)
+	startAttempt(h, host, e.Attempt, as)
+	as.entry.usedPool(executionPoolHost(e, host))
+	if as.close && !as.drain {
 		r.Close = true
 	}
+
+	// Watch which connection the attempt is sent on, so that it can be
+	// recycled or closed based on its own history.
+	if h.conns != nil {
+		e.Request = traceConnection(h, host, e.Attempt, r, as)
+	}
+
+	// Annotate the execution with the decision.
+	e.SetValue(DecisionKey, as.decision(host, e.Attempt))
 }
 
 func afterAttempt(h *handler, e *request.Execution) {
@@ -91,66 +369,492 @@ func afterAttempt(h *handler, e *request.Execution) {
 	}
 
 	// Determine attempt end time.
-	es := getExecutionState(h, e)
+	es := getExecutionState(h, e, host)
 	if es == nil {
 		return
 	}
-	if e.Attempt >= len(es.attemptStart) {
-		h.Logger.Printf("reconnx: ERROR: unexpected attempt end (%d)", e.Attempt)
+	if e.Attempt >= len(es.attempts) {
+		reportError(h, host, e.Attempt, "reconnx: ERROR: unexpected attempt end (%d)", e.Attempt)
 		return
 	}
-	d := time.Now().Sub(es.attemptStart[e.Attempt])
+	out := attemptOutcome{
+		end:        time.Now(),
+		closed:     e.Request.Close,
+		err:        e.Err,
+		statusCode: e.StatusCode(),
+		poolHost:   executionPoolHost(e, host),
+	}
+	if e.Response != nil {
+		out.http2 = e.Response.ProtoMajor == 2
+	}
 
-	// Push the attempt time into the host latency state machine.
-	sm := getHostLatencyStateMachine(h, host)
+	// Push the attempt time into the host latency state machine and
+	// complete the decision annotation with the attempt outcome.
+	if decision, ok := finishAttempt(h, host, e.Attempt, es.attempts[e.Attempt], out); ok {
+		e.SetValue(DecisionKey, decision)
+	}
+}
+
+// startAttempt consults the host's state machine at the start of a
+// request attempt, and decides whether the attempt's connection should
+// be closed, or the host's connections drained, when the attempt
+// finishes.
+func startAttempt(h *handler, host string, attempt int, as *attemptState) {
+	sm := getOrCreateHostLatencyStateMachine(h, host)
+	as.entry = sm
+	as.state = sm.State()
+	if as.state == Closing && !h.PerConnection {
+		as.drain = strategy(h, host) == StrategyDrain && !drainFallback(h, host, attempt, sm, as.start)
+		requestClose(h, host, attempt, as, HistoryCloseRequest, machineStats(sm.Machine, Closing))
+	}
+}
+
+// drainFallback reports whether the plugin closes a host's connections
+// instead of draining them, logging the drain's timeout if it just
+// timed out.
+func drainFallback(h *handler, host string, attempt int, sm *hostEntry, t time.Time) bool {
+	fallback, timedOut := sm.fallBack(t)
+	if timedOut {
+		logMessage(h, LevelWarn, "drain timed out",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}},
+			"reconnx: drain of connections to %s timed out before attempt %d, closing connections instead", host, attempt)
+	}
+	return fallback
+}
+
+// requestClose records and reports the decision to close the
+// connection used by a request attempt after the attempt ends. The
+// kind tells whether the host's Machine or the connection's is in the
+// Closing state, and the stats are those of that Machine.
+func requestClose(h *handler, host string, attempt int, as *attemptState, kind HistoryKind, stats MachineStats) {
+	logMessage(h, LevelDebug, "connection will be closed after attempt ends",
+		[]Field{{FieldHost, host}, {FieldAttempt, attempt}, {FieldState, Closing}},
+		"reconnx: a connection to %s will be closed after attempt %d ends", host, attempt)
+	as.close = true
+	as.trigger = stats.Reason
+	if sm := attemptHostEntry(h, host, as); sm != nil {
+		sm.requestedClose(attempt, kind, as.state, stats, as.start)
+	}
+	h.counters.requestedClose()
+	h.Metrics.Counter(MetricCloseRequests, 1, Tag{TagHost, host})
+	h.Listener.CloseRequested(newHostEvent(host, attempt, as.state, as.state, stats))
+}
+
+// An attemptOutcome describes how a request attempt ended.
+type attemptOutcome struct {
+	end        time.Time
+	closed     bool
+	err        error
+	statusCode int
+	poolHost   string
+	http2      bool
+}
+
+// finishAttempt pushes the latency of a finished request attempt into
+// the host's state machine and reports the result. The second return
+// value is false if the host has no state machine.
+//
+// A drained connection is only counted as closed once the drain has
+// completed, so that the host's Machine does not leave the Closing state
+// before traffic has really moved onto new connections. When the plugin
+// closes an HTTP/2 connection and a Pool is configured, the host's
+// connection pool is also retired.
+func finishAttempt(h *handler, host string, attempt int, as *attemptState, out attemptOutcome) (Decision, bool) {
+	sample := float64(out.end.Sub(as.start).Milliseconds())
+	sm := attemptHostEntry(h, host, as)
 	if sm == nil {
-		h.Logger.Printf("reconnx: ERROR: missing latency state machine for host (%s)", host)
-		return
+		reportError(h, host, attempt, "reconnx: ERROR: missing latency state machine for host (%s)", host)
+		return Decision{}, false
+	}
+	closed := out.closed
+	if as.drain {
+		closed = drainHost(h, host, attempt, out.poolHost, sm, out.end)
+	} else if out.http2 && closed && (as.close || as.recycled) && h.Pool != nil {
+		retireHTTP2(h, host, attempt, out.poolHost)
+	}
+	if closed && as.close && as.peer.ip != "" && h.Quarantine != nil {
+		quarantinePeer(h, host, attempt, as.peer, out.end)
 	}
-	next, prev := sm.Next(float64(d.Milliseconds()), e.Request.Close)
+	tr := nextTransition(sm.Machine, sample, closed)
+	prev, next := tr.Prev, tr.Next
+	sm.observed(attempt, tr, out.end)
+	if prev == Closing && next != Closing {
+		sm.resetDrain()
+	}
+	h.counters.observed(prev, next)
+	stats := machineStats(sm.Machine, next)
 	if prev != next {
-		h.Logger.Printf("reconnx: after attempt %d, host %s state changed from %s to %s", e.Attempt, host, prev, next)
+		logMessage(h, LevelInfo, "host state changed",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}, {FieldPrevState, prev}, {FieldState, next}, {FieldReason, tr.Reason}},
+			"reconnx: after attempt %d, host %s state changed from %s to %s (%s)", attempt, host, prev, next, tr.Reason)
+		h.Listener.StateChanged(transitionEvent(host, attempt, tr))
+		if next == Closing && h.Pool != nil {
+			closeIdle(h, host, attempt, out.poolHost)
+		}
+	}
+	reportAttemptMetrics(h, host, sample, prev, next, sm.Machine, stats)
+	if as.conn != "" {
+		finishConnAttempt(h, host, attempt, as, sample, closed)
+		if h.outliers != nil {
+			observePeer(h, host, attempt, as.peer, sample, out.end)
+		}
 	}
+
+	decision := as.decision(host, attempt)
+	decision.HTTP2 = out.http2
+	decision.Done = true
+	decision.Sample = sample
+	decision.ErrorClass = errorClass(out.err)
+	if decision.ErrorClass != "" && !as.close {
+		decision.Trigger = ReasonError
+	}
+
+	// Record the attempt if a recorder is configured.
+	if h.Recorder != nil {
+		h.Recorder.Record(Record{
+			Time:       out.end,
+			Host:       host,
+			Attempt:    attempt,
+			Latency:    sample,
+			ErrorClass: decision.ErrorClass,
+			StatusCode: out.statusCode,
+			Close:      as.close || as.recycled,
+			Prev:       prev,
+			Next:       next,
+		})
+	}
+
+	return decision, true
 }
 
+// getExecutionHost returns the host key of an execution. The second
+// return value is false if the execution has no plan, or if the plugin
+// does not manage the host.
 func getExecutionHost(h *handler, e *request.Execution) (string, bool) {
 	p := e.Plan
 	if p == nil {
-		h.Logger.Printf(missingExecutionPlanMsg)
+		reportError(h, "", e.Attempt, missingExecutionPlanMsg)
 		return "", false
 	}
 
-	return p.Host, true
+	return p.Host, managed(h, p.Host)
 }
 
-func getExecutionState(h *handler, e *request.Execution) *executionState {
+// executionPoolHost returns the Host of the URL of an execution's plan,
+// which identifies the connection pool its attempts are sent on, or the
+// host key if the plan has no URL.
+func executionPoolHost(e *request.Execution, host string) string {
+	if e.Plan.URL != nil {
+		return e.Plan.URL.Host
+	}
+
+	return host
+}
+
+func getExecutionState(h *handler, e *request.Execution, host string) *executionState {
 	es, _ := e.Value(executionStateKey).(*executionState)
 	if es == nil {
-		h.Logger.Printf(missingExecutionStateMsg)
+		reportError(h, host, e.Attempt, missingExecutionStateMsg)
 		return nil
 	}
 
 	return es
 }
 
-func getHostLatencyStateMachine(h *handler, host string) Machine {
+// finishConnAttempt pushes the latency of a finished request attempt
+// into the state machine of the connection it was sent on, if the
+// connection has one. The connection's Machine counts the attempt as
+// closing a connection if the plugin asked for the connection to be
+// closed. Connections which were closed are forgotten.
+func finishConnAttempt(h *handler, host string, attempt int, as *attemptState, sample float64, closed bool) {
+	cm := h.conns.connMachine(as.conn)
+	if closed {
+		h.conns.forget(as.conn)
+	}
+	if cm == nil {
+		return
+	}
+
+	tr := nextTransition(cm, sample, as.close || as.recycled)
+	if tr.Prev != tr.Next {
+		logMessage(h, LevelDebug, "connection state changed",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}, {FieldPrevState, tr.Prev}, {FieldState, tr.Next}, {FieldReason, tr.Reason}},
+			"reconnx: after attempt %d, connection %s to host %s state changed from %s to %s (%s)", attempt, as.conn, host, tr.Prev, tr.Next, tr.Reason)
+	}
+}
+
+// observePeer pushes the latency of a finished request attempt into the
+// outlier detector, and reports peers which are ejected or readmitted.
+func observePeer(h *handler, host string, attempt int, peer connPeer, sample float64, t time.Time) {
+	ejected, readmitted := h.outliers.observe(peer, sample, t)
+	if ejected {
+		logMessage(h, LevelInfo, "peer ejected as outlier",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}, {FieldPeer, peer.ip}},
+			"reconnx: after attempt %d, peer %s of host %s ejected as outlier", attempt, peer.ip, host)
+		h.Metrics.Counter(MetricEjections, 1, Tag{TagHost, host})
+		if h.Quarantine != nil {
+			quarantinePeer(h, host, attempt, peer, t)
+		}
+		if h.Pool != nil {
+			closeIdle(h, host, attempt, peer.poolHost)
+		}
+	} else if readmitted {
+		logMessage(h, LevelInfo, "peer readmitted",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}, {FieldPeer, peer.ip}},
+			"reconnx: after attempt %d, peer %s of host %s readmitted", attempt, peer.ip, host)
+	}
+}
+
+// retireHTTP2 retires the connection pool in the Pool for the server
+// identified by poolHost, so that requests which were already waiting
+// for the HTTP/2 connection shared by all requests to the server also
+// move onto a new connection.
+func retireHTTP2(h *handler, host string, attempt int, poolHost string) {
+	logMessage(h, LevelDebug, "retiring HTTP/2 connection",
+		[]Field{{FieldHost, host}, {FieldAttempt, attempt}},
+		"reconnx: retiring HTTP/2 connection to %s after attempt %d", host, attempt)
+	h.Pool.Retire(poolHost)
+}
+
+// drainHost starts draining the connections in the pool to the server
+// identified by poolHost, unless a drain has already started in the
+// host's current Closing period, and returns whether the drain has
+// completed.
+//
+// If the connections cannot be drained, because no Pool is configured
+// or because the Pool has no connections to the host, the plugin falls
+// back to closing the host's connections for the rest of the Closing
+// period.
+func drainHost(h *handler, host string, attempt int, poolHost string, sm *hostEntry, t time.Time) bool {
+	if h.Pool == nil {
+		logMessage(h, LevelDebug, "cannot drain connections without pool",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}},
+			"reconnx: cannot drain connections to %s after attempt %d without a Pool", host, attempt)
+		sm.failDrain()
+		return false
+	}
+
+	gen, ok := sm.beginDrain(t)
+	if !ok {
+		return sm.isDrained()
+	}
+	logMessage(h, LevelDebug, "draining connections",
+		[]Field{{FieldHost, host}, {FieldAttempt, attempt}},
+		"reconnx: draining connections to %s after attempt %d", host, attempt)
+	ok = h.Pool.Drain(poolHost, func() {
+		logMessage(h, LevelDebug, "connections drained",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}},
+			"reconnx: connections to %s drained after attempt %d", host, attempt)
+		h.Metrics.Counter(MetricDrains, 1, Tag{TagHost, host})
+		sm.drainDone(gen)
+	})
+	if !ok {
+		logMessage(h, LevelWarn, "cannot drain connections unknown to pool",
+			[]Field{{FieldHost, host}, {FieldAttempt, attempt}},
+			"reconnx: cannot drain connections to %s after attempt %d, since the Pool has none, closing connections instead", host, attempt)
+		sm.failDrain()
+		return false
+	}
+	return sm.isDrained()
+}
+
+// quarantinePeer quarantines the address of a peer of a host for the
+// peer's host name, so that new connections to the host name avoid it.
+func quarantinePeer(h *handler, host string, attempt int, peer connPeer, t time.Time) {
+	logMessage(h, LevelDebug, "peer quarantined",
+		[]Field{{FieldHost, host}, {FieldAttempt, attempt}, {FieldPeer, peer.ip}},
+		"reconnx: after attempt %d, peer %s of host %s quarantined", attempt, peer.ip, host)
+	h.Quarantine.add(peer.hostname(), peer.ip, t)
+}
+
+// closeIdle closes the idle connections in the pool to the server
+// identified by poolHost.
+func closeIdle(h *handler, host string, attempt int, poolHost string) {
+	logMessage(h, LevelDebug, "closing idle connections",
+		[]Field{{FieldHost, host}, {FieldAttempt, attempt}},
+		"reconnx: closing idle connections to %s after attempt %d", host, attempt)
+	h.Pool.CloseIdle(poolHost)
+}
+
+// logMessage sends a message to the structured logger, if there is
+// one, or otherwise formats it with the Printf-style logger.
+func logMessage(h *handler, level Level, msg string, fields []Field, format string, v ...interface{}) {
+	if h.StructuredLogger != nil {
+		h.StructuredLogger.Log(level, msg, fields...)
+		return
+	}
+
+	h.Logger.Printf(format, v...)
+}
+
+// reportError logs an internal error and reports it to the listener.
+func reportError(h *handler, host string, attempt int, format string, v ...interface{}) {
+	msg := strings.TrimPrefix(fmt.Sprintf(format, v...), errorPrefix)
+	fields := []Field{{FieldAttempt, attempt}, {FieldReason, msg}}
+	if host != "" {
+		fields = append([]Field{{FieldHost, host}}, fields...)
+	}
+	logMessage(h, LevelError, "internal error", fields, format, v...)
+	h.counters.erred()
+	h.Metrics.Counter(MetricErrors, 1)
+	h.Listener.Error(HostEvent{
+		Host:    host,
+		Attempt: attempt,
+		Err:     errors.New("reconnx: " + msg),
+	})
+}
+
+// reportAttemptMetrics sends the metrics describing a completed
+// attempt to the configured Metrics.
+func reportAttemptMetrics(h *handler, host string, latency float64, prev, next State, sm Machine, stats MachineStats) {
+	hostTag := Tag{TagHost, host}
+	h.Metrics.Counter(MetricAttempts, 1, hostTag, Tag{TagState, next.String()})
+	h.Metrics.Histogram(MetricLatency, latency, hostTag)
+	if prev != next {
+		h.Metrics.Counter(MetricTransitions, 1, hostTag, Tag{TagFrom, prev.String()}, Tag{TagTo, next.String()})
+	}
+	if _, ok := sm.(statsMachine); ok {
+		h.Metrics.Gauge(MetricRecentAvg, stats.RecentAvg, hostTag)
+		h.Metrics.Gauge(MetricHistoricalAvg, stats.HistoricalAvg, hostTag)
+	}
+}
+
+func getHostLatencyStateMachine(h *handler, host string) *hostEntry {
 	h.hostLatencyLock.RLock()
 	defer h.hostLatencyLock.RUnlock()
 	return h.hostLatency[host]
 }
 
-func getOrCreateHostLatencyStateMachine(h *handler, host string) Machine {
+// attemptHostEntry returns the host entry consulted when a request
+// attempt started, which stays valid even if the host has since been
+// evicted, or the host's current entry if the attempt has none.
+func attemptHostEntry(h *handler, host string, as *attemptState) *hostEntry {
+	if as.entry != nil {
+		return as.entry
+	}
+
+	return getHostLatencyStateMachine(h, host)
+}
+
+func getOrCreateHostLatencyStateMachine(h *handler, host string) *hostEntry {
+	sm, evicted := getOrCreateHostEntry(h, host, time.Now())
+	for _, ev := range evicted {
+		reportEviction(h, ev)
+	}
+	return sm
+}
+
+// A hostEviction records that a host was evicted, and why, together
+// with the host's connection pool in the Pool.
+type hostEviction struct {
+	host     string
+	cause    string
+	poolHost string
+}
+
+const (
+	evictionMaxHosts = "max_hosts"
+	evictionIdle     = "idle"
+)
+
+// getOrCreateHostEntry returns the entry for a host, creating it if
+// necessary, and marks the host as the most recently used. Hosts which
+// have been idle for longer than HostIdleTTL are evicted first, and if
+// there are then more than MaxHosts hosts, the least recently used ones
+// are evicted.
+func getOrCreateHostEntry(h *handler, host string, now time.Time) (*hostEntry, []hostEviction) {
 	h.hostLatencyLock.Lock()
 	defer h.hostLatencyLock.Unlock()
-	if sm, ok := h.hostLatency[host]; ok {
-		return sm
+	if h.hostOrder == nil {
+		h.hostOrder = list.New()
 	}
-	sm := NewMachine(h.Latency)
-	h.hostLatency[host] = sm
-	return sm
+
+	var evicted []hostEviction
+	if h.HostIdleTTL > 0 {
+		for e := h.hostOrder.Back(); e != nil; e = h.hostOrder.Back() {
+			idle := e.Value.(string)
+			if now.Sub(h.hostLatency[idle].lastUsed) < h.HostIdleTTL {
+				break
+			}
+			evicted = append(evicted, hostEviction{idle, evictionIdle, evictHost(h, idle)})
+		}
+	}
+
+	sm, ok := h.hostLatency[host]
+	if !ok {
+		sm = newHostEntry(NewMachine(latencyConfig(h, host)), h.HistorySize)
+		h.hostLatency[host] = sm
+	}
+	if sm.elem == nil {
+		sm.elem = h.hostOrder.PushFront(host)
+	} else {
+		h.hostOrder.MoveToFront(sm.elem)
+	}
+	sm.lastUsed = now
+
+	if h.MaxHosts > 0 {
+		for len(h.hostLatency) > h.MaxHosts {
+			e := h.hostOrder.Back()
+			if e == nil || e == sm.elem {
+				break
+			}
+			lru := e.Value.(string)
+			evicted = append(evicted, hostEviction{lru, evictionMaxHosts, evictHost(h, lru)})
+		}
+	}
+
+	return sm, evicted
+}
+
+// evictHost removes a host's entry, and returns the host's connection
+// pool in the Pool. The caller must hold the write lock on
+// hostLatencyLock.
+func evictHost(h *handler, host string) string {
+	sm := h.hostLatency[host]
+	h.hostOrder.Remove(sm.elem)
+	delete(h.hostLatency, host)
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return sm.poolHost
 }
 
+// reportEviction logs and reports the eviction of a host, and discards
+// the host's connection pool in the Pool.
+func reportEviction(h *handler, ev hostEviction) {
+	logMessage(h, LevelDebug, "host evicted",
+		[]Field{{FieldHost, ev.host}, {FieldReason, ev.cause}},
+		"reconnx: host %s evicted (%s)", ev.host, ev.cause)
+	h.counters.evicted()
+	h.Metrics.Counter(MetricEvictions, 1, Tag{TagHost, ev.host}, Tag{TagCause, ev.cause})
+	if h.Pool != nil && ev.poolHost != "" {
+		h.Pool.Forget(ev.poolHost)
+	}
+}
+
+// latencyConfig returns the MachineConfig for a host, which is the
+// override in HostLatency whose pattern best matches the host, or
+// Latency if there is none.
+func latencyConfig(h *handler, host string) MachineConfig {
+	if len(h.HostLatency) == 0 {
+		return h.Latency
+	}
+
+	patterns := make([]string, 0, len(h.HostLatency))
+	for pattern := range h.HostLatency {
+		patterns = append(patterns, pattern)
+	}
+	if pattern, ok := bestMatch(patterns, host); ok {
+		return h.HostLatency[pattern]
+	}
+	return h.Latency
+}
+
+// drainTimeout is how long a drain of a host's connections may take
+// before the plugin falls back to closing them.
+const drainTimeout = time.Minute
+
 const (
+	errorPrefix                = "reconnx: ERROR: "
 	unsupportedEventMsg        = "reconnx: unsupported event"
 	missingExecutionPlanMsg    = "reconnx: ERROR: missing execution plan"
 	missingExecutionRequestMsg = "reconnx: ERROR: missing execution request"
@@ -5,12 +5,21 @@
 package reconnx
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/gogama/httpx"
+	"github.com/gogama/httpx/racing"
 	"github.com/gogama/httpx/request"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -59,10 +68,17 @@ func testBeforeAttempt(t *testing.T) {
 	t.Run("MissingExecutionPlan", func(t *testing.T) {
 		h, l := newHandlerWithLogger(t)
 		l.On("Printf", missingExecutionPlanMsg).Once()
+		ml := newMockListener(t)
+		ml.On("Error", HostEvent{
+			Attempt: 2,
+			Err:     errors.New("reconnx: missing execution plan"),
+		}).Once()
+		h.Listener = ml
 
-		h.Handle(httpx.BeforeAttempt, &request.Execution{})
+		h.Handle(httpx.BeforeAttempt, &request.Execution{Attempt: 2})
 
 		l.AssertExpectations(t)
+		ml.AssertExpectations(t)
 	})
 	t.Run("MissingExecutionRequest", func(t *testing.T) {
 		h, l := newHandlerWithLogger(t)
@@ -115,10 +131,30 @@ func testBeforeAttempt(t *testing.T) {
 
 		l.AssertExpectations(t)
 		assert.Contains(t, h.hostLatency, "foo.com")
-		m := h.hostLatency["foo.com"]
+		m := h.hostLatency["foo.com"].Machine
 		require.IsType(t, &machine{}, m)
 		assert.Equal(t, h.Config.Latency, m.(*machine).config)
 	})
+	t.Run("ExcludedHost", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		h.filter = newHostFilter(nil, []string{".example.com"})
+		p, err := request.NewPlan("", "https://stream.example.com", nil)
+		require.NoError(t, err)
+		e := &request.Execution{
+			Plan:    p,
+			Request: &http.Request{},
+		}
+		es := &executionState{}
+		e.SetValue(executionStateKey, es)
+
+		h.Handle(httpx.BeforeAttempt, e)
+		h.Handle(httpx.AfterAttempt, e)
+
+		l.AssertExpectations(t)
+		assert.Empty(t, h.hostLatency)
+		assert.Empty(t, es.attempts)
+		assert.False(t, e.Request.Close)
+	})
 	t.Run("ExistingLatencyStateMachineForHost", func(t *testing.T) {
 		t.Run("NotClosingState", func(t *testing.T) {
 			h, l := newHandlerWithLogger(t)
@@ -130,12 +166,12 @@ func testBeforeAttempt(t *testing.T) {
 			}
 			e.SetValue(executionStateKey, &executionState{})
 			m1 := &machine{}
-			h.hostLatency["bar.org"] = m1
+			h.hostLatency["bar.org"] = &hostEntry{Machine: m1}
 
 			l.AssertExpectations(t)
 
 			h.Handle(httpx.BeforeAttempt, e)
-			m2 := h.hostLatency["bar.org"]
+			m2 := h.hostLatency["bar.org"].Machine
 			require.IsType(t, &machine{}, m2)
 			assert.Same(t, m1, m2.(*machine))
 			assert.False(t, e.Request.Close)
@@ -151,20 +187,65 @@ func testBeforeAttempt(t *testing.T) {
 				Attempt: 1,
 			}
 			e.SetValue(executionStateKey, &executionState{
-				attemptStart: make([]time.Time, 1),
+				attempts: []*attemptState{{}},
 			})
-			m1 := &machine{
-				state: Closing,
-			}
-			h.hostLatency["baz.edu"] = m1
+			m1 := NewMachine(MachineConfig{}).(*machine)
+			m1.state = Closing
+			m1.reason = ReasonPctThreshold
+			h.hostLatency["baz.edu"] = &hostEntry{Machine: m1}
+			ml := newMockListener(t)
+			ml.On("CloseRequested", HostEvent{
+				Host:    "baz.edu",
+				Attempt: 1,
+				Prev:    Closing,
+				Next:    Closing,
+				Reason:  ReasonPctThreshold,
+			}).Once()
+			h.Listener = ml
 
 			h.Handle(httpx.BeforeAttempt, e)
 
 			l.AssertExpectations(t)
-			m2 := h.hostLatency["baz.edu"]
+			ml.AssertExpectations(t)
+			m2 := h.hostLatency["baz.edu"].Machine
 			require.IsType(t, &machine{}, m2)
 			assert.Same(t, m1, m2.(*machine))
 			assert.True(t, e.Request.Close)
+			d, ok := DecisionOf(e)
+			require.True(t, ok)
+			assert.Equal(t, Decision{
+				Host:    "baz.edu",
+				Attempt: 1,
+				State:   Closing,
+				Close:   true,
+				Trigger: ReasonPctThreshold,
+			}, d)
+		})
+		t.Run("ClosingStateDrain", func(t *testing.T) {
+			h, l := newHandlerWithLogger(t)
+			l.On("Printf", "reconnx: a connection to %s will be closed after attempt %d ends", []interface{}{"baz.edu", 0}).Once()
+			h.Strategies = map[string]Strategy{"baz.edu": StrategyDrain}
+			e := &request.Execution{
+				Plan:    &request.Plan{Host: "baz.edu"},
+				Request: &http.Request{},
+			}
+			e.SetValue(executionStateKey, &executionState{})
+			m := NewMachine(MachineConfig{}).(*machine)
+			m.state = Closing
+			h.hostLatency["baz.edu"] = &hostEntry{Machine: m}
+
+			h.Handle(httpx.BeforeAttempt, e)
+
+			l.AssertExpectations(t)
+			assert.False(t, e.Request.Close)
+			d, ok := DecisionOf(e)
+			require.True(t, ok)
+			assert.Equal(t, Decision{
+				Host:  "baz.edu",
+				State: Closing,
+				Close: true,
+				Drain: true,
+			}, d)
 		})
 	})
 }
@@ -217,7 +298,7 @@ func testAfterAttempt(t *testing.T) {
 			Request: &http.Request{},
 		}
 		e.SetValue(executionStateKey, &executionState{
-			attemptStart: []time.Time{time.Now()},
+			attempts: []*attemptState{{start: time.Now()}},
 		})
 
 		h.Handle(httpx.AfterAttempt, e)
@@ -243,9 +324,9 @@ func testAfterAttempt(t *testing.T) {
 						Attempt: 1,
 					}
 					e.SetValue(executionStateKey, &executionState{
-						attemptStart: []time.Time{time.Now(), time.Now()},
+						attempts: []*attemptState{{start: time.Now()}, {start: time.Now()}},
 					})
-					h.hostLatency["spam"] = m
+					h.hostLatency["spam"] = &hostEntry{Machine: m}
 
 					h.Handle(httpx.AfterAttempt, e)
 
@@ -274,18 +355,492 @@ func testAfterAttempt(t *testing.T) {
 						},
 					}
 					e.SetValue(executionStateKey, &executionState{
-						attemptStart: []time.Time{time.Now()},
+						attempts: []*attemptState{{start: time.Now()}},
 					})
-					h.hostLatency["wham!"] = m
+					h.hostLatency["wham!"] = &hostEntry{Machine: m}
+					ml := newMockListener(t)
+					ml.On("StateChanged", HostEvent{
+						Host: "wham!",
+						Prev: Resting,
+						Next: Closing,
+					}).Once()
+					h.Listener = ml
 
 					h.Handle(httpx.AfterAttempt, e)
 
 					l.AssertExpectations(t)
 					m.AssertExpectations(t)
-					assert.Equal(t, "reconnx: after attempt 0, host wham! state changed from Resting to Closing", infoMsg)
+					ml.AssertExpectations(t)
+					assert.Equal(t, "reconnx: after attempt 0, host wham! state changed from Resting to Closing (None)", infoMsg)
 				})
 			}
 		})
+		t.Run("TransitionReason", func(t *testing.T) {
+			h, l := newHandlerWithLogger(t)
+			var infoMsg string
+			l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).
+				Run(renderPrintf(&infoMsg)).
+				Once()
+			e := &request.Execution{
+				Plan:    &request.Plan{Host: "eggs"},
+				Request: &http.Request{},
+			}
+			e.SetValue(executionStateKey, &executionState{
+				attempts: []*attemptState{{start: time.Now().Add(-time.Second)}},
+			})
+			h.hostLatency["eggs"] = &hostEntry{Machine: NewMachine(MachineConfig{
+				AbsThreshold:  1.0,
+				RecentSamples: 1,
+				ClosingStreak: 1,
+				ClosingCount:  1,
+			})}
+			var evt HostEvent
+			ml := newMockListener(t)
+			ml.On("StateChanged", mock.AnythingOfType("HostEvent")).
+				Run(func(args mock.Arguments) {
+					evt = args.Get(0).(HostEvent)
+				}).
+				Once()
+			h.Listener = ml
+
+			h.Handle(httpx.AfterAttempt, e)
+
+			l.AssertExpectations(t)
+			ml.AssertExpectations(t)
+			assert.Equal(t, "reconnx: after attempt 0, host eggs state changed from Watching to Closing (AbsThreshold)", infoMsg)
+			assert.Equal(t, Watching, evt.Prev)
+			assert.Equal(t, Closing, evt.Next)
+			assert.Equal(t, ReasonAbsThreshold, evt.Reason)
+		})
+		t.Run("Pool", func(t *testing.T) {
+			server, closed := newConnTrackingServer(t)
+			defer server.Close()
+			h, l := newHandlerWithLogger(t)
+			var debugMsg string
+			l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).Once()
+			l.On("Printf", "reconnx: closing idle connections to %s after attempt %d", mock.AnythingOfType("[]interface {}")).
+				Run(renderPrintf(&debugMsg)).
+				Once()
+			h.Pool = NewPool(nil)
+			get(t, &http.Client{Transport: h.Pool}, server.URL)
+			m := newMockMachine(t)
+			m.On("Next", mock.AnythingOfType("float64"), false).Return(Closing, Watching).Once()
+			h.hostLatency["spam"] = &hostEntry{Machine: m}
+			p, err := request.NewPlan("", server.URL, nil)
+			require.NoError(t, err)
+			p.Host = "spam"
+			e := &request.Execution{
+				Plan:    p,
+				Request: &http.Request{},
+			}
+			e.SetValue(executionStateKey, &executionState{
+				attempts: []*attemptState{{start: time.Now()}},
+			})
+
+			h.Handle(httpx.AfterAttempt, e)
+
+			l.AssertExpectations(t)
+			m.AssertExpectations(t)
+			assert.Equal(t, "reconnx: closing idle connections to spam after attempt 0", debugMsg)
+			assert.Eventually(t, func() bool { return atomic.LoadInt32(closed) == 1 }, time.Second, time.Millisecond)
+		})
+		t.Run("HTTP2", func(t *testing.T) {
+			for _, pool := range []bool{false, true} {
+				t.Run(fmt.Sprintf("pool:%t", pool), func(t *testing.T) {
+					h, l := newHandlerWithLogger(t)
+					var debugMsg string
+					m := newMockMachine(t)
+					m.On("Next", mock.AnythingOfType("float64"), true).Return(Closing, Closing).Once()
+					h.hostLatency["spam"] = &hostEntry{Machine: m}
+					var before *http.Transport
+					if pool {
+						l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).
+							Run(renderPrintf(&debugMsg)).
+							Once()
+						h.Pool = NewPool(nil)
+						before = h.Pool.transportLocked("eggs:443")
+					}
+					e := &request.Execution{
+						Plan:     &request.Plan{Host: "spam", URL: &url.URL{Host: "eggs:443"}},
+						Request:  &http.Request{Close: true},
+						Response: &http.Response{ProtoMajor: 2},
+					}
+					e.SetValue(executionStateKey, &executionState{
+						attempts: []*attemptState{{start: time.Now(), state: Closing, close: true}},
+					})
+
+					h.Handle(httpx.AfterAttempt, e)
+
+					l.AssertExpectations(t)
+					m.AssertExpectations(t)
+					d, ok := DecisionOf(e)
+					require.True(t, ok)
+					assert.True(t, d.HTTP2)
+					assert.True(t, d.Close)
+					if pool {
+						assert.Equal(t, "reconnx: retiring HTTP/2 connection to spam after attempt 0", debugMsg)
+						assert.NotSame(t, before, h.Pool.transportLocked("eggs:443"))
+					} else {
+						assert.Empty(t, debugMsg)
+					}
+				})
+			}
+		})
+		t.Run("HTTP2NotClosing", func(t *testing.T) {
+			h, l := newHandlerWithLogger(t)
+			m := newMockMachine(t)
+			m.On("Next", mock.AnythingOfType("float64"), true).Return(Watching, Watching).Once()
+			h.hostLatency["spam"] = &hostEntry{Machine: m}
+			h.Pool = NewPool(nil)
+			e := &request.Execution{
+				Plan:     &request.Plan{Host: "spam"},
+				Request:  &http.Request{Close: true},
+				Response: &http.Response{ProtoMajor: 2},
+			}
+			e.SetValue(executionStateKey, &executionState{
+				attempts: []*attemptState{{start: time.Now()}},
+			})
+
+			h.Handle(httpx.AfterAttempt, e)
+
+			l.AssertExpectations(t)
+			m.AssertExpectations(t)
+			assert.Empty(t, h.Pool.transports)
+		})
+		t.Run("Drain", func(t *testing.T) {
+			for _, pool := range []bool{false, true} {
+				t.Run(fmt.Sprintf("pool:%t", pool), func(t *testing.T) {
+					h, l := newHandlerWithLogger(t)
+					var msgs []string
+					l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).
+						Run(func(args mock.Arguments) {
+							var msg string
+							renderPrintf(&msg)(args)
+							msgs = append(msgs, msg)
+						})
+					mm := newMockMetrics(t)
+					mm.On("Counter", mock.AnythingOfType("string"), mock.AnythingOfType("int64"), mock.Anything)
+					mm.On("Histogram", mock.AnythingOfType("string"), mock.AnythingOfType("float64"), mock.Anything)
+					h.Metrics = mm
+					m := newMockMachine(t)
+					m.On("Next", mock.AnythingOfType("float64"), pool).Return(Closing, Closing).Once()
+					h.hostLatency["spam"] = &hostEntry{Machine: m}
+					if pool {
+						h.Pool = NewPool(nil)
+						h.Pool.transportLocked("eggs:80")
+					}
+					e := &request.Execution{
+						Plan:    &request.Plan{Host: "spam", URL: &url.URL{Host: "eggs:80"}},
+						Request: &http.Request{},
+					}
+					e.SetValue(executionStateKey, &executionState{
+						attempts: []*attemptState{{start: time.Now(), state: Closing, close: true, drain: true}},
+					})
+
+					h.Handle(httpx.AfterAttempt, e)
+
+					l.AssertExpectations(t)
+					m.AssertExpectations(t)
+					if pool {
+						assert.Equal(t, []string{
+							"reconnx: draining connections to spam after attempt 0",
+							"reconnx: connections to spam drained after attempt 0",
+						}, msgs)
+						mm.AssertCalled(t, "Counter", MetricDrains, int64(1), []Tag{{TagHost, "spam"}})
+					} else {
+						assert.Equal(t, []string{"reconnx: cannot drain connections to spam after attempt 0 without a Pool"}, msgs)
+					}
+					d, ok := DecisionOf(e)
+					require.True(t, ok)
+					assert.True(t, d.Drain)
+				})
+			}
+		})
+		t.Run("Decision", func(t *testing.T) {
+			h, l := newHandlerWithLogger(t)
+			m := newMockMachine(t)
+			m.
+				On("Next", mock.AnythingOfType("float64"), true).
+				Return(Watching, Closing).
+				Once()
+			l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).Once()
+			e := &request.Execution{
+				Plan: &request.Plan{Host: "ham"},
+				Request: &http.Request{
+					Close: true,
+				},
+				Err: syscall.ECONNRESET,
+			}
+			e.SetValue(executionStateKey, &executionState{
+				attempts: []*attemptState{{start: time.Now(), state: Closing, close: true, trigger: ReasonAbsThreshold}},
+			})
+			h.hostLatency["ham"] = &hostEntry{Machine: m}
+
+			h.Handle(httpx.AfterAttempt, e)
+
+			l.AssertExpectations(t)
+			m.AssertExpectations(t)
+			d, ok := DecisionOf(e)
+			require.True(t, ok)
+			assert.Equal(t, Decision{
+				Host:       "ham",
+				State:      Closing,
+				Close:      true,
+				Trigger:    ReasonAbsThreshold,
+				Done:       true,
+				Sample:     d.Sample,
+				ErrorClass: "conn_reset",
+			}, d)
+		})
+		t.Run("DecisionError", func(t *testing.T) {
+			h, l := newHandlerWithLogger(t)
+			m := newMockMachine(t)
+			m.On("Next", mock.AnythingOfType("float64"), false).Return(Watching, Watching).Once()
+			e := &request.Execution{
+				Plan:    &request.Plan{Host: "ham"},
+				Request: &http.Request{},
+				Err:     syscall.ECONNRESET,
+			}
+			e.SetValue(executionStateKey, &executionState{
+				attempts: []*attemptState{{start: time.Now()}},
+			})
+			h.hostLatency["ham"] = &hostEntry{Machine: m}
+
+			h.Handle(httpx.AfterAttempt, e)
+
+			l.AssertExpectations(t)
+			m.AssertExpectations(t)
+			d, ok := DecisionOf(e)
+			require.True(t, ok)
+			assert.False(t, d.Close)
+			assert.Equal(t, ReasonError, d.Trigger)
+			assert.Equal(t, "conn_reset", d.ErrorClass)
+		})
+		t.Run("Recorder", func(t *testing.T) {
+			for _, closed := range []bool{false, true} {
+				t.Run(fmt.Sprintf("closed:%t", closed), func(t *testing.T) {
+					h, l := newHandlerWithLogger(t)
+					m := newMockMachine(t)
+					r := newMockRecorder(t)
+					h.Recorder = r
+					var rec Record
+					m.
+						On("Next", mock.AnythingOfType("float64"), closed).
+						Return(Watching, Watching).
+						Once()
+					r.
+						On("Record", mock.AnythingOfType("Record")).
+						Run(func(args mock.Arguments) {
+							rec = args.Get(0).(Record)
+						}).
+						Once()
+					e := &request.Execution{
+						Plan: &request.Plan{Host: "eggs"},
+						Request: &http.Request{
+							Close: closed,
+						},
+						Response: &http.Response{
+							StatusCode: 429,
+						},
+						Attempt: 1,
+					}
+					start := time.Now()
+					e.SetValue(executionStateKey, &executionState{
+						attempts: []*attemptState{{}, {start: start, close: closed}},
+					})
+					h.hostLatency["eggs"] = &hostEntry{Machine: m}
+
+					h.Handle(httpx.AfterAttempt, e)
+
+					l.AssertExpectations(t)
+					m.AssertExpectations(t)
+					r.AssertExpectations(t)
+					assert.False(t, rec.Time.Before(start))
+					assert.Equal(t, Record{
+						Time:       rec.Time,
+						Host:       "eggs",
+						Attempt:    1,
+						Latency:    rec.Latency,
+						StatusCode: 429,
+						Close:      closed,
+						Prev:       Watching,
+						Next:       Watching,
+					}, rec)
+				})
+			}
+		})
+	})
+}
+
+func TestHandler_StructuredLogger(t *testing.T) {
+	t.Run("Error", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		sl := newMockStructuredLogger(t)
+		sl.On("Log", LevelError, "internal error", []Field{
+			{FieldAttempt, 0},
+			{FieldReason, "missing execution plan"},
+		}).Once()
+		h.StructuredLogger = sl
+
+		h.Handle(httpx.AfterAttempt, &request.Execution{})
+
+		l.AssertExpectations(t)
+		sl.AssertExpectations(t)
+	})
+	t.Run("ErrorWithHost", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		sl := newMockStructuredLogger(t)
+		sl.On("Log", LevelError, "internal error", []Field{
+			{FieldHost, "foo"},
+			{FieldAttempt, 3},
+			{FieldReason, "unexpected attempt end (3)"},
+		}).Once()
+		h.StructuredLogger = sl
+		e := &request.Execution{
+			Plan:    &request.Plan{Host: "foo"},
+			Request: &http.Request{},
+			Attempt: 3,
+		}
+		e.SetValue(executionStateKey, &executionState{})
+
+		h.Handle(httpx.AfterAttempt, e)
+
+		l.AssertExpectations(t)
+		sl.AssertExpectations(t)
+	})
+	t.Run("CloseRequested", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		sl := newMockStructuredLogger(t)
+		sl.On("Log", LevelDebug, "connection will be closed after attempt ends", []Field{
+			{FieldHost, "bar"},
+			{FieldAttempt, 0},
+			{FieldState, Closing},
+		}).Once()
+		h.StructuredLogger = sl
+		e := &request.Execution{
+			Plan:    &request.Plan{Host: "bar"},
+			Request: &http.Request{},
+		}
+		e.SetValue(executionStateKey, &executionState{})
+		h.hostLatency["bar"] = &hostEntry{Machine: &machine{state: Closing}}
+
+		h.Handle(httpx.BeforeAttempt, e)
+
+		l.AssertExpectations(t)
+		sl.AssertExpectations(t)
+		assert.True(t, e.Request.Close)
+	})
+	t.Run("StateChanged", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		sl := newMockStructuredLogger(t)
+		sl.On("Log", LevelInfo, "host state changed", []Field{
+			{FieldHost, "baz"},
+			{FieldAttempt, 0},
+			{FieldPrevState, Watching},
+			{FieldState, Closing},
+			{FieldReason, ReasonNone},
+		}).Once()
+		h.StructuredLogger = sl
+		m := newMockMachine(t)
+		m.On("Next", mock.AnythingOfType("float64"), false).Return(Closing, Watching).Once()
+		h.hostLatency["baz"] = &hostEntry{Machine: m}
+		e := &request.Execution{
+			Plan:    &request.Plan{Host: "baz"},
+			Request: &http.Request{},
+		}
+		e.SetValue(executionStateKey, &executionState{
+			attempts: []*attemptState{{start: time.Now()}},
+		})
+
+		h.Handle(httpx.AfterAttempt, e)
+
+		l.AssertExpectations(t)
+		sl.AssertExpectations(t)
+		m.AssertExpectations(t)
+	})
+}
+
+func TestHandler_Metrics(t *testing.T) {
+	t.Run("Error", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		l.On("Printf", missingExecutionPlanMsg).Once()
+		mm := newMockMetrics(t)
+		mm.On("Counter", MetricErrors, int64(1), []Tag(nil)).Once()
+		h.Metrics = mm
+
+		h.Handle(httpx.BeforeAttempt, &request.Execution{})
+
+		l.AssertExpectations(t)
+		mm.AssertExpectations(t)
+	})
+	t.Run("CloseRequested", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).Once()
+		mm := newMockMetrics(t)
+		mm.On("Counter", MetricCloseRequests, int64(1), []Tag{{TagHost, "foo"}}).Once()
+		h.Metrics = mm
+		h.hostLatency["foo"] = &hostEntry{Machine: &machine{state: Closing}}
+		e := &request.Execution{
+			Plan:    &request.Plan{Host: "foo"},
+			Request: &http.Request{},
+		}
+		e.SetValue(executionStateKey, &executionState{})
+
+		h.Handle(httpx.BeforeAttempt, e)
+
+		l.AssertExpectations(t)
+		mm.AssertExpectations(t)
+	})
+	t.Run("Attempt", func(t *testing.T) {
+		t.Run("StatsMachine", func(t *testing.T) {
+			h, l := newHandlerWithLogger(t)
+			mm := newMockMetrics(t)
+			hostTag := Tag{TagHost, "bar"}
+			mm.On("Counter", MetricAttempts, int64(1), []Tag{hostTag, {TagState, "Watching"}}).Once()
+			mm.On("Histogram", MetricLatency, mock.AnythingOfType("float64"), []Tag{hostTag}).Once()
+			mm.On("Gauge", MetricRecentAvg, mock.AnythingOfType("float64"), []Tag{hostTag}).Once()
+			mm.On("Gauge", MetricHistoricalAvg, 0.0, []Tag{hostTag}).Once()
+			h.Metrics = mm
+			h.hostLatency["bar"] = &hostEntry{Machine: NewMachine(MachineConfig{})}
+			e := &request.Execution{
+				Plan:    &request.Plan{Host: "bar"},
+				Request: &http.Request{},
+			}
+			e.SetValue(executionStateKey, &executionState{
+				attempts: []*attemptState{{start: time.Now()}},
+			})
+
+			h.Handle(httpx.AfterAttempt, e)
+
+			l.AssertExpectations(t)
+			mm.AssertExpectations(t)
+		})
+		t.Run("Transition", func(t *testing.T) {
+			h, l := newHandlerWithLogger(t)
+			l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).Once()
+			m := newMockMachine(t)
+			m.On("Next", mock.AnythingOfType("float64"), false).Return(Resting, Closing).Once()
+			mm := newMockMetrics(t)
+			hostTag := Tag{TagHost, "baz"}
+			mm.On("Counter", MetricAttempts, int64(1), []Tag{hostTag, {TagState, "Resting"}}).Once()
+			mm.On("Histogram", MetricLatency, mock.AnythingOfType("float64"), []Tag{hostTag}).Once()
+			mm.On("Counter", MetricTransitions, int64(1), []Tag{hostTag, {TagFrom, "Closing"}, {TagTo, "Resting"}}).Once()
+			h.Metrics = mm
+			h.hostLatency["baz"] = &hostEntry{Machine: m}
+			e := &request.Execution{
+				Plan:    &request.Plan{Host: "baz"},
+				Request: &http.Request{},
+			}
+			e.SetValue(executionStateKey, &executionState{
+				attempts: []*attemptState{{start: time.Now()}},
+			})
+
+			h.Handle(httpx.AfterAttempt, e)
+
+			l.AssertExpectations(t)
+			m.AssertExpectations(t)
+			mm.AssertExpectations(t)
+		})
 	})
 }
 
@@ -297,12 +852,346 @@ func renderPrintf(target *string) func(args mock.Arguments) {
 	}
 }
 
+func TestLatencyConfig(t *testing.T) {
+	h := newHandler(Config{
+		Latency: MachineConfig{AbsThreshold: 100.0},
+		HostLatency: map[string]MachineConfig{
+			"cache.example.com": {AbsThreshold: 20.0},
+			".example.com":      {AbsThreshold: 500.0},
+			"report-*":          {AbsThreshold: 2000.0},
+		},
+	})
+
+	assert.Equal(t, 20.0, latencyConfig(h, "cache.example.com").AbsThreshold)
+	assert.Equal(t, 500.0, latencyConfig(h, "www.example.com").AbsThreshold)
+	assert.Equal(t, 2000.0, latencyConfig(h, "report-eu").AbsThreshold)
+	assert.Equal(t, 100.0, latencyConfig(h, "other.org").AbsThreshold)
+	sm := getOrCreateHostLatencyStateMachine(h, "cache.example.com")
+	require.IsType(t, &machine{}, sm.Machine)
+	assert.Equal(t, 20.0, sm.Machine.(*machine).config.AbsThreshold)
+}
+
+func TestHostEviction(t *testing.T) {
+	now := time.Now()
+	t.Run("MaxHosts", func(t *testing.T) {
+		h := newHandler(Config{MaxHosts: 2})
+		a, _ := getOrCreateHostEntry(h, "a", now)
+		getOrCreateHostEntry(h, "b", now)
+		a2, evicted := getOrCreateHostEntry(h, "a", now)
+		assert.Same(t, a, a2)
+		assert.Empty(t, evicted)
+
+		_, evicted = getOrCreateHostEntry(h, "c", now)
+
+		assert.Equal(t, []hostEviction{{"b", evictionMaxHosts, ""}}, evicted)
+		assert.Len(t, h.hostLatency, 2)
+		assert.Contains(t, h.hostLatency, "a")
+		assert.Contains(t, h.hostLatency, "c")
+		assert.Equal(t, 2, h.hostOrder.Len())
+	})
+	t.Run("HostIdleTTL", func(t *testing.T) {
+		h := newHandler(Config{HostIdleTTL: time.Minute})
+		a, _ := getOrCreateHostEntry(h, "a", now)
+		getOrCreateHostEntry(h, "b", now.Add(30*time.Second))
+
+		_, evicted := getOrCreateHostEntry(h, "c", now.Add(time.Minute))
+		assert.Equal(t, []hostEviction{{"a", evictionIdle, ""}}, evicted)
+		a2, evicted := getOrCreateHostEntry(h, "a", now.Add(3*time.Minute))
+		assert.Equal(t, []hostEviction{{"b", evictionIdle, ""}, {"c", evictionIdle, ""}}, evicted)
+
+		assert.NotSame(t, a, a2)
+		assert.Len(t, h.hostLatency, 1)
+	})
+	t.Run("Report", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		var debugMsg string
+		l.On("Printf", "reconnx: host %s evicted (%s)", mock.AnythingOfType("[]interface {}")).
+			Run(renderPrintf(&debugMsg)).
+			Once()
+		mm := newMockMetrics(t)
+		mm.On("Counter", MetricEvictions, int64(1), []Tag{{TagHost, "a"}, {TagCause, evictionMaxHosts}}).Once()
+		h.Metrics = mm
+		h.MaxHosts = 1
+		getOrCreateHostLatencyStateMachine(h, "a")
+
+		getOrCreateHostLatencyStateMachine(h, "b")
+
+		l.AssertExpectations(t)
+		mm.AssertExpectations(t)
+		assert.Equal(t, "reconnx: host a evicted (max_hosts)", debugMsg)
+		assert.Equal(t, uint64(1), h.counters.snapshot().Evictions)
+	})
+	t.Run("InFlight", func(t *testing.T) {
+		h := newHandler(Config{MaxHosts: 1})
+		e := &request.Execution{
+			Plan:    &request.Plan{Host: "a"},
+			Request: &http.Request{},
+		}
+		h.Handle(httpx.BeforeExecutionStart, e)
+		h.Handle(httpx.BeforeAttempt, e)
+		runAttempt(h, "b")
+
+		h.Handle(httpx.AfterAttempt, e)
+
+		counters := h.counters.snapshot()
+		assert.Equal(t, uint64(0), counters.Errors)
+		assert.Equal(t, uint64(2), counters.Attempts)
+		assert.Equal(t, uint64(1), counters.Evictions)
+		assert.Equal(t, []string{"b"}, (&Plugin{h: h}).Hosts())
+	})
+	t.Run("Pool", func(t *testing.T) {
+		s1, closed1 := newConnTrackingServer(t)
+		defer s1.Close()
+		s2, _ := newConnTrackingServer(t)
+		defer s2.Close()
+		pool := NewPool(nil)
+		cl := &httpx.Client{HTTPDoer: &http.Client{Transport: pool}}
+		OnClient(cl, Config{Pool: pool, MaxHosts: 1})
+
+		_, err := cl.Get(s1.URL)
+		require.NoError(t, err)
+		assert.Len(t, pool.transports, 1)
+		_, err = cl.Get(s2.URL)
+		require.NoError(t, err)
+
+		assert.Len(t, pool.transports, 1)
+		assert.Contains(t, pool.transports, hostOf(t, s2.URL))
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(closed1) == 1 }, time.Second, time.Millisecond)
+	})
+}
+
+func TestDrain(t *testing.T) {
+	newClosingHandler := func() (*handler, *machine, *hostEntry) {
+		h := newHandler(Config{Pool: NewPool(nil), Strategy: StrategyDrain})
+		m := NewMachine(MachineConfig{AbsThreshold: 1e6, ClosingStreak: 2, ClosingCount: 10}).(*machine)
+		m.state = Closing
+		sm := newHostEntry(m, 0)
+		h.hostLatency["foo"] = sm
+		return h, m, sm
+	}
+	t.Run("Once", func(t *testing.T) {
+		h, m, sm := newClosingHandler()
+		held := h.Pool.acquire("foo")
+
+		d, _ := DecisionOf(runAttempt(h, "foo"))
+		assert.True(t, d.Drain)
+		retired := h.Pool.transports["foo"]
+		assert.NotSame(t, held, retired)
+		d, _ = DecisionOf(runAttempt(h, "foo"))
+		assert.True(t, d.Drain)
+		assert.Same(t, retired, h.Pool.transports["foo"])
+		assert.Equal(t, uint(0), m.closedStreak)
+
+		h.Pool.release(held)
+		runAttempt(h, "foo")
+		assert.Equal(t, Closing, m.state)
+		assert.Equal(t, uint(1), m.closedStreak)
+		runAttempt(h, "foo")
+
+		assert.NotEqual(t, Closing, m.state)
+		assert.Same(t, retired, h.Pool.transports["foo"])
+		assert.Equal(t, uint64(1), sm.drainGen)
+		assert.True(t, sm.drainStart.IsZero())
+		assert.False(t, sm.drained)
+	})
+	t.Run("UnknownToPool", func(t *testing.T) {
+		h, _, sm := newClosingHandler()
+
+		e := runAttempt(h, "foo")
+		d, _ := DecisionOf(e)
+		assert.True(t, d.Drain)
+		assert.False(t, e.Request.Close)
+		assert.True(t, sm.drainFallback)
+		e = runAttempt(h, "foo")
+
+		d, _ = DecisionOf(e)
+		assert.False(t, d.Drain)
+		assert.True(t, d.Close)
+		assert.True(t, e.Request.Close)
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		h, _, sm := newClosingHandler()
+		h.Pool.acquire("foo")
+		runAttempt(h, "foo")
+		sm.drainStart = sm.drainStart.Add(-drainTimeout)
+
+		e := runAttempt(h, "foo")
+
+		d, _ := DecisionOf(e)
+		assert.False(t, d.Drain)
+		assert.True(t, e.Request.Close)
+	})
+}
+
 func newHandlerWithLogger(t *testing.T) (*handler, *mockLogger) {
 	l := newMockLogger(t)
 	return &handler{
 		Config: Config{
-			Logger: l,
+			Logger:   l,
+			Listener: NopListener{},
+			Metrics:  NopMetrics{},
 		},
-		hostLatency: map[string]Machine{},
+		hostLatency: map[string]*hostEntry{},
 	}, l
 }
+
+func TestPerConnection(t *testing.T) {
+	t.Run("HostClosingDoesNotClose", func(t *testing.T) {
+		h := newHandler(Config{PerConnection: true})
+		h.hostLatency["foo"] = &hostEntry{Machine: &machine{state: Closing}}
+		as := &attemptState{}
+
+		startAttempt(h, "foo", 0, as)
+
+		assert.Equal(t, Closing, as.state)
+		assert.False(t, as.close)
+		require.NotNil(t, h.conns)
+		assert.NotNil(t, h.conns.machine())
+	})
+	t.Run("Client", func(t *testing.T) {
+		var lock sync.Mutex
+		var conns []string
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			slow := len(conns) > 0 && conns[0] == r.RemoteAddr
+			lock.Unlock()
+			if slow {
+				time.Sleep(20 * time.Millisecond)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				lock.Lock()
+				conns = append(conns, c.RemoteAddr().String())
+				lock.Unlock()
+			}
+		}
+		server.Start()
+		defer server.Close()
+		cl := &httpx.Client{HTTPDoer: &http.Client{Transport: &http.Transport{}}}
+		p := Install(cl, Config{
+			PerConnection: true,
+			Latency: MachineConfig{
+				RecentSamples: 1,
+				AbsThreshold:  10.0,
+				ClosingStreak: 1,
+				ClosingCount:  1,
+			},
+		})
+		var decisions []Decision
+		cl.Handlers.PushBack(httpx.AfterAttempt, httpx.HandlerFunc(func(_ httpx.Event, e *request.Execution) {
+			d, ok := DecisionOf(e)
+			require.True(t, ok)
+			decisions = append(decisions, d)
+		}))
+
+		for i := 0; i < 4; i++ {
+			_, err := cl.Get(server.URL)
+			require.NoError(t, err)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		require.Len(t, conns, 2)
+		require.Len(t, decisions, 4)
+		assert.False(t, decisions[0].Close)
+		assert.True(t, decisions[1].Close)
+		assert.Equal(t, ReasonAbsThreshold, decisions[1].Trigger)
+		assert.Equal(t, decisions[0].Conn, decisions[1].Conn)
+		assert.False(t, decisions[2].Close)
+		assert.False(t, decisions[3].Close)
+		assert.NotEqual(t, decisions[1].Conn, decisions[2].Conn)
+		assert.Equal(t, decisions[2].Conn, decisions[3].Conn)
+		stats, ok := p.Stats(hostOf(t, server.URL))
+		require.True(t, ok)
+		assert.Equal(t, uint64(1), stats.CloseRequests)
+		history, ok := p.History(hostOf(t, server.URL))
+		require.True(t, ok)
+		require.Len(t, history, 3)
+		assert.Equal(t, HistoryConnCloseRequest, history[1].Kind)
+		assert.Equal(t, ReasonAbsThreshold, history[1].Reason)
+	})
+	t.Run("HostWatchingHistory", func(t *testing.T) {
+		h := newHandler(Config{PerConnection: true})
+		sm := getOrCreateHostLatencyStateMachine(h, "foo")
+		as := &attemptState{entry: sm, state: Watching}
+		ml := newMockListener(t)
+		ml.On("CloseRequested", HostEvent{
+			Host:   "foo",
+			Prev:   Watching,
+			Next:   Watching,
+			Reason: ReasonAbsThreshold,
+		}).Once()
+		h.Listener = ml
+
+		requestClose(h, "foo", 0, as, HistoryConnCloseRequest, MachineStats{State: Closing, Reason: ReasonAbsThreshold})
+
+		ml.AssertExpectations(t)
+		history := sm.recentHistory()
+		require.Len(t, history, 1)
+		assert.Equal(t, HistoryConnCloseRequest, history[0].Kind)
+		assert.Equal(t, Watching, history[0].Prev)
+		assert.Equal(t, Watching, history[0].Next)
+	})
+	t.Run("ConnectionCountsClose", func(t *testing.T) {
+		h := newHandler(Config{
+			PerConnection: true,
+			Latency:       MachineConfig{AbsThreshold: 1e6, ClosingStreak: 1, ClosingCount: 1},
+		})
+		h.conns.use("c", connPeer{poolHost: "foo", ip: "10.0.0.1"}, false, time.Now())
+		cm := h.conns.connMachine("c").(*machine)
+		cm.state = Closing
+
+		finishConnAttempt(h, "foo", 0, &attemptState{conn: "c", close: true}, 1.0, true)
+
+		assert.NotEqual(t, Closing, cm.state)
+		assert.Equal(t, ReasonClosingStreak, cm.reason)
+		assert.Nil(t, h.conns.connMachine("c"))
+	})
+}
+
+func TestRacing(t *testing.T) {
+	var started int32
+	cl := &httpx.Client{
+		HTTPDoer: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			n := atomic.AddInt32(&started, 1) - 1
+			if n == 0 {
+				time.Sleep(30 * time.Millisecond)
+			}
+			trace := httptrace.ContextClientTrace(r.Context())
+			require.NotNil(t, trace)
+			trace.GotConn(httptrace.GotConnInfo{
+				Conn: &fakeConn{local: fmt.Sprintf("10.1.1.1:%d", 1000+n), remote: "10.0.0.1:80"},
+			})
+			if n > 0 {
+				select {
+				case <-r.Context().Done():
+					return nil, r.Context().Err()
+				case <-time.After(60 * time.Millisecond):
+				}
+			}
+			return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+		})},
+		RacingPolicy: racing.NewPolicy(racing.NewStaticScheduler(time.Millisecond, time.Millisecond, time.Millisecond), racing.AlwaysStart),
+	}
+	OnClient(cl, Config{Recycle: RecycleConfig{MaxRequests: 100}})
+	var decisions []Decision
+	cl.Handlers.PushBack(httpx.AfterAttempt, httpx.HandlerFunc(func(_ httpx.Event, e *request.Execution) {
+		d, ok := DecisionOf(e)
+		require.True(t, ok)
+		decisions = append(decisions, d)
+	}))
+
+	_, err := cl.Get("http://foo")
+	require.NoError(t, err)
+
+	require.Len(t, decisions, 3)
+	conns := map[string]bool{}
+	for _, d := range decisions {
+		assert.True(t, d.Done)
+		assert.NotEmpty(t, d.Conn, "attempt %d", d.Attempt)
+		conns[d.Conn] = true
+	}
+	assert.Len(t, conns, 3)
+}
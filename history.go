@@ -0,0 +1,150 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultHistorySize is the default number of history entries kept for
+// each host if the HistorySize field of a Config is zero.
+const DefaultHistorySize = 32
+
+// A HistoryKind identifies the kind of a HistoryEntry.
+type HistoryKind int
+
+const (
+	// HistoryTransition indicates that the host's Machine transitioned
+	// from one state to another.
+	HistoryTransition HistoryKind = iota
+
+	// HistoryCloseRequest indicates that the plugin requested that the
+	// connection used by a request attempt be closed.
+	HistoryCloseRequest
+
+	// HistoryConnCloseRequest indicates that the plugin requested that
+	// the connection used by a request attempt be closed because, in
+	// per-connection mode, the connection's own Machine was in the
+	// Closing state. Prev and Next are the host Machine's state, while
+	// Reason and the averages and counters are the connection Machine's.
+	HistoryConnCloseRequest
+)
+
+func (k HistoryKind) String() string {
+	switch k {
+	case HistoryTransition:
+		return "Transition"
+	case HistoryCloseRequest:
+		return "CloseRequest"
+	case HistoryConnCloseRequest:
+		return "ConnCloseRequest"
+	default:
+		return ""
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. The
+// text form of a HistoryKind is the same as its String value.
+func (k HistoryKind) MarshalText() ([]byte, error) {
+	str := k.String()
+	if str == "" {
+		return nil, fmt.Errorf("reconnx: invalid history kind %d", int(k))
+	}
+	return []byte(str), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (k *HistoryKind) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "Transition":
+		*k = HistoryTransition
+	case "CloseRequest":
+		*k = HistoryCloseRequest
+	case "ConnCloseRequest":
+		*k = HistoryConnCloseRequest
+	default:
+		return fmt.Errorf("reconnx: invalid history kind %q", text)
+	}
+	return nil
+}
+
+// A HistoryEntry records a state transition or close decision made by
+// the reconnx plugin for a single host, together with the host
+// Machine's averages and counters at the time.
+type HistoryEntry struct {
+	// Time is the time at which the transition or decision was made.
+	Time time.Time
+
+	// Kind is the kind of the entry.
+	Kind HistoryKind
+
+	// Attempt is the zero-based attempt number, within its execution,
+	// of the request attempt which led to the entry.
+	Attempt int
+
+	// Prev is the host Machine's state before the entry. For close
+	// requests, Prev is equal to Next.
+	Prev State
+
+	// Next is the host Machine's state after the entry.
+	Next State
+
+	// Reason explains why the host Machine entered the Next state.
+	Reason Reason
+
+	// RecentAvg is the host Machine's recent average at the time.
+	RecentAvg float64
+
+	// HistoricalAvg is the host Machine's historical average at the
+	// time.
+	HistoricalAvg float64
+
+	// ClosedStreak is the host Machine's closed connection streak at
+	// the time.
+	ClosedStreak uint
+
+	// ClosedCount is the host Machine's closed connection count at the
+	// time.
+	ClosedCount uint
+}
+
+// A history is a fixed-capacity ring of history entries which drops
+// the oldest entry when a new one is pushed while it is full.
+type history struct {
+	entries []HistoryEntry
+	start   int
+	n       int
+}
+
+func newHistory(size int) history {
+	if size <= 0 {
+		return history{}
+	}
+
+	return history{entries: make([]HistoryEntry, size)}
+}
+
+func (hi *history) push(entry HistoryEntry) {
+	size := len(hi.entries)
+	if size == 0 {
+		return
+	}
+
+	hi.entries[(hi.start+hi.n)%size] = entry
+	if hi.n < size {
+		hi.n++
+	} else {
+		hi.start = (hi.start + 1) % size
+	}
+}
+
+func (hi *history) slice() []HistoryEntry {
+	s := make([]HistoryEntry, hi.n)
+	for i := range s {
+		s[i] = hi.entries[(hi.start+i)%len(hi.entries)]
+	}
+	return s
+}
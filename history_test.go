@@ -0,0 +1,81 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryKind_String(t *testing.T) {
+	assert.Equal(t, "Transition", HistoryTransition.String())
+	assert.Equal(t, "CloseRequest", HistoryCloseRequest.String())
+	assert.Equal(t, "ConnCloseRequest", HistoryConnCloseRequest.String())
+	assert.Equal(t, "", HistoryKind(-1).String())
+}
+
+func TestHistoryKind_Text(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, k := range []HistoryKind{HistoryTransition, HistoryCloseRequest, HistoryConnCloseRequest} {
+			b, err := k.MarshalText()
+			require.NoError(t, err)
+			assert.Equal(t, k.String(), string(b))
+			var k2 HistoryKind
+			err = k2.UnmarshalText(b)
+			require.NoError(t, err)
+			assert.Equal(t, k, k2)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := HistoryKind(-1).MarshalText()
+		assert.EqualError(t, err, "reconnx: invalid history kind -1")
+		var k HistoryKind
+		err = k.UnmarshalText([]byte("Nap"))
+		assert.EqualError(t, err, `reconnx: invalid history kind "Nap"`)
+	})
+}
+
+func TestHistory(t *testing.T) {
+	t.Run("Zero", func(t *testing.T) {
+		var hi history
+		hi.push(HistoryEntry{Attempt: 1})
+
+		assert.Empty(t, hi.slice())
+	})
+	t.Run("NonPositiveSize", func(t *testing.T) {
+		for _, size := range []int{0, -1} {
+			hi := newHistory(size)
+			hi.push(HistoryEntry{Attempt: 1})
+
+			assert.Empty(t, hi.slice())
+		}
+	})
+	t.Run("Wrap", func(t *testing.T) {
+		hi := newHistory(3)
+		attempts := func() []int {
+			s := hi.slice()
+			a := make([]int, len(s))
+			for i := range s {
+				a[i] = s[i].Attempt
+			}
+			return a
+		}
+
+		assert.Equal(t, []int{}, attempts())
+		hi.push(HistoryEntry{Attempt: 1})
+		hi.push(HistoryEntry{Attempt: 2})
+		assert.Equal(t, []int{1, 2}, attempts())
+		hi.push(HistoryEntry{Attempt: 3})
+		assert.Equal(t, []int{1, 2, 3}, attempts())
+		hi.push(HistoryEntry{Attempt: 4})
+		assert.Equal(t, []int{2, 3, 4}, attempts())
+		hi.push(HistoryEntry{Attempt: 5})
+		hi.push(HistoryEntry{Attempt: 6})
+		hi.push(HistoryEntry{Attempt: 7})
+		assert.Equal(t, []int{5, 6, 7}, attempts())
+	})
+}
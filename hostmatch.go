@@ -0,0 +1,235 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"net"
+	"path"
+	"strings"
+	"sync"
+)
+
+// A patternKind identifies how a host pattern is matched against host
+// keys.
+//
+// A host pattern is one of:
+//
+//   - an exact host name, such as "api.example.com", which matches only
+//     that host, or an exact IP address, such as "10.0.0.1" or "[::1]";
+//   - a suffix starting with a dot, such as ".example.com", which matches
+//     example.com and all of its subdomains;
+//   - a CIDR block, such as "10.0.0.0/8" or "fd00::/8", which matches
+//     hosts which are literal IP addresses within the block;
+//   - a glob containing any of the characters '*', '?' or '[', such as
+//     "api-*.example.com", which is matched using path.Match.
+//
+// Host patterns are matched case-insensitively. A host key which
+// includes a port matches a pattern if either the whole host key or
+// the host key without its port matches.
+type patternKind int
+
+const (
+	patternExact patternKind = iota
+	patternCIDR
+	patternSuffix
+	patternGlob
+)
+
+func kindOf(pattern string) patternKind {
+	if _, _, err := net.ParseCIDR(pattern); err == nil {
+		return patternCIDR
+	} else if bracketedIP(pattern) {
+		return patternExact
+	} else if strings.ContainsAny(pattern, "*?[") {
+		return patternGlob
+	} else if strings.HasPrefix(pattern, ".") {
+		return patternSuffix
+	}
+	return patternExact
+}
+
+// bracketedIP reports whether pattern is a literal IPv6 address in
+// brackets, with or without a port, such as "[::1]" or "[::1]:8080",
+// rather than a glob.
+func bracketedIP(pattern string) bool {
+	if !strings.HasPrefix(pattern, "[") {
+		return false
+	}
+	return net.ParseIP(stripPort(pattern)) != nil
+}
+
+// A hostPattern is a host pattern compiled for matching.
+type hostPattern struct {
+	kind    patternKind
+	pattern string
+	ipNet   *net.IPNet
+}
+
+func compilePattern(pattern string) hostPattern {
+	hp := hostPattern{kind: kindOf(pattern), pattern: strings.ToLower(pattern)}
+	switch hp.kind {
+	case patternCIDR:
+		_, hp.ipNet, _ = net.ParseCIDR(pattern)
+	case patternExact:
+		if strings.HasSuffix(hp.pattern, "]") {
+			hp.pattern = strings.Trim(hp.pattern, "[]")
+		}
+	}
+	return hp
+}
+
+// match reports whether host matches the pattern. A glob pattern which
+// is malformed matches no host.
+func (hp *hostPattern) match(host string) bool {
+	host = strings.ToLower(host)
+	name := stripPort(host)
+	switch hp.kind {
+	case patternCIDR:
+		ip := net.ParseIP(name)
+		return ip != nil && hp.ipNet.Contains(ip)
+	case patternGlob:
+		return matchGlob(hp.pattern, host) || matchGlob(hp.pattern, name)
+	case patternSuffix:
+		return strings.HasSuffix(name, hp.pattern) || name == hp.pattern[1:]
+	default:
+		return host == hp.pattern || name == hp.pattern
+	}
+}
+
+// matchHost reports whether host matches pattern.
+func matchHost(pattern, host string) bool {
+	hp := compilePattern(pattern)
+	return hp.match(host)
+}
+
+func matchGlob(pattern, host string) bool {
+	ok, err := path.Match(pattern, host)
+	return ok && err == nil
+}
+
+// validPattern reports whether pattern is a well-formed host pattern.
+// Only globs and patterns which look like CIDR blocks can be malformed.
+func validPattern(pattern string) bool {
+	switch kindOf(pattern) {
+	case patternGlob:
+		_, err := path.Match(pattern, "")
+		return err == nil
+	case patternExact:
+		return !strings.Contains(pattern, "/")
+	default:
+		return true
+	}
+}
+
+// stripPort returns a host key without its port, if it has one, and
+// without the brackets around a literal IPv6 address.
+func stripPort(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return strings.Trim(host, "[]")
+}
+
+// hostFilterCacheSize is the maximum number of host keys whose result
+// a hostFilter caches. The cache is emptied when it is full.
+const hostFilterCacheSize = 1024
+
+// A hostFilter decides which hosts the plugin manages, according to
+// the Config's Include and Exclude patterns, which it compiles once.
+// It caches its decision for each host key.
+type hostFilter struct {
+	include []hostPattern
+	exclude []hostPattern
+	lock    sync.RWMutex
+	cache   map[string]bool
+}
+
+// newHostFilter returns a hostFilter for the Include and Exclude
+// patterns, or nil if there are none, in which case every host is
+// managed.
+func newHostFilter(include, exclude []string) *hostFilter {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+
+	f := &hostFilter{cache: map[string]bool{}}
+	for _, pattern := range include {
+		f.include = append(f.include, compilePattern(pattern))
+	}
+	for _, pattern := range exclude {
+		f.exclude = append(f.exclude, compilePattern(pattern))
+	}
+	return f
+}
+
+func (f *hostFilter) managed(host string) bool {
+	f.lock.RLock()
+	ok, cached := f.cache[host]
+	f.lock.RUnlock()
+	if cached {
+		return ok
+	}
+
+	ok = f.evaluate(host)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if len(f.cache) >= hostFilterCacheSize {
+		f.cache = map[string]bool{}
+	}
+	f.cache[host] = ok
+	return ok
+}
+
+func (f *hostFilter) evaluate(host string) bool {
+	for i := range f.exclude {
+		if f.exclude[i].match(host) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for i := range f.include {
+		if f.include[i].match(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// managed reports whether the plugin manages a host, according to the
+// Config's Include and Exclude patterns.
+func managed(h *handler, host string) bool {
+	return h.filter == nil || h.filter.managed(host)
+}
+
+// bestMatch returns the most specific of the patterns which match host.
+// An exact pattern is more specific than a CIDR block, a CIDR block is
+// more specific than a suffix, and a suffix is more specific than a
+// glob. Among patterns of the same kind, the longer one
+// is more specific, and ties are broken in favor of the pattern which
+// sorts first. The second return value is false if no pattern matches.
+func bestMatch(patterns []string, host string) (string, bool) {
+	var best string
+	var found bool
+	for _, pattern := range patterns {
+		if !matchHost(pattern, host) {
+			continue
+		}
+		if !found || moreSpecific(pattern, best) {
+			best, found = pattern, true
+		}
+	}
+	return best, found
+}
+
+func moreSpecific(a, b string) bool {
+	if ka, kb := kindOf(a), kindOf(b); ka != kb {
+		return ka < kb
+	} else if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a < b
+}
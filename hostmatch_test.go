@@ -0,0 +1,135 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchHost(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		host    string
+		match   bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"api.example.com", "API.Example.com", true},
+		{"api.example.com", "x.api.example.com", false},
+		{".example.com", "example.com", true},
+		{".example.com", "api.example.com", true},
+		{".example.com", "a.b.example.com", true},
+		{".example.com", "badexample.com", false},
+		{"api-*.example.com", "api-1.example.com", true},
+		{"api-*.example.com", "api.example.com", false},
+		{"api-?.example.com", "api-12.example.com", false},
+		{"api-[0-9].example.com", "api-7.example.com", true},
+		{"[", "[", false},
+		{"localhost", "localhost:8080", true},
+		{"localhost:8080", "localhost:8080", true},
+		{"localhost:8080", "localhost:9090", false},
+		{".example.com", "api.example.com:443", true},
+		{"*.example.com:443", "api.example.com:443", true},
+		{"*.example.com", "api.example.com:443", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "10.1.2.3:80", true},
+		{"10.0.0.0/8", "11.1.2.3", false},
+		{"10.0.0.0/8", "10.example.com", false},
+		{"fd00::/8", "[fd00::1]:80", true},
+		{"fd00::/8", "[::1]:80", false},
+		{"169.254.169.254", "169.254.169.254:80", true},
+		{"[::1]", "[::1]:8080", true},
+		{"[::1]", "[::1]", true},
+		{"[::1]", "::1", true},
+		{"[::1]", "[::2]:8080", false},
+		{"[::1]:8080", "[::1]:8080", true},
+		{"[::1]:8080", "[::1]:9090", false},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.match, matchHost(testCase.pattern, testCase.host), "pattern %q, host %q", testCase.pattern, testCase.host)
+	}
+}
+
+func TestValidPattern(t *testing.T) {
+	assert.True(t, validPattern("api.example.com"))
+	assert.True(t, validPattern(".example.com"))
+	assert.True(t, validPattern("10.0.0.0/8"))
+	assert.True(t, validPattern("api-*"))
+	assert.True(t, validPattern("[::1]"))
+	assert.True(t, validPattern("[::1]:8080"))
+	assert.False(t, validPattern("["))
+	assert.False(t, validPattern("10.0.0.0/33"))
+}
+
+func TestManaged(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		h := newHandler(Config{})
+
+		assert.Nil(t, h.filter)
+		assert.True(t, managed(h, "foo"))
+	})
+	t.Run("Exclude", func(t *testing.T) {
+		h := newHandler(Config{Exclude: []string{"localhost", "127.0.0.0/8", "[::1]"}})
+
+		assert.False(t, managed(h, "localhost:8080"))
+		assert.False(t, managed(h, "127.0.0.1:9000"))
+		assert.False(t, managed(h, "[::1]:9000"))
+		assert.True(t, managed(h, "foo"))
+	})
+	t.Run("Cache", func(t *testing.T) {
+		h := newHandler(Config{Exclude: []string{"localhost"}})
+		require.NotNil(t, h.filter)
+
+		assert.False(t, managed(h, "localhost"))
+		assert.True(t, managed(h, "foo"))
+
+		assert.Equal(t, map[string]bool{"localhost": false, "foo": true}, h.filter.cache)
+		for i := len(h.filter.cache); i < hostFilterCacheSize; i++ {
+			managed(h, fmt.Sprintf("host%d", i))
+		}
+		assert.Len(t, h.filter.cache, hostFilterCacheSize)
+		assert.False(t, managed(h, "localhost:80"))
+		assert.Len(t, h.filter.cache, 1)
+	})
+	t.Run("Include", func(t *testing.T) {
+		h := newHandler(Config{
+			Include: []string{".example.com"},
+			Exclude: []string{"stream.example.com"},
+		})
+
+		assert.True(t, managed(h, "api.example.com"))
+		assert.False(t, managed(h, "stream.example.com"))
+		assert.False(t, managed(h, "foo"))
+	})
+}
+
+func TestBestMatch(t *testing.T) {
+	patterns := []string{"*.example.com", "*", ".example.com", ".api.example.com", "api.example.com", "?.example.com", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3"}
+	testCases := []struct {
+		host    string
+		pattern string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"v1.api.example.com", ".api.example.com"},
+		{"www.example.com", ".example.com"},
+		{"other.org", "*"},
+		{"10.2.0.1", "10.0.0.0/8"},
+		{"10.1.0.1", "10.1.0.0/16"},
+		{"10.1.2.3", "10.1.2.3"},
+	}
+	for _, testCase := range testCases {
+		pattern, ok := bestMatch(patterns, testCase.host)
+		assert.True(t, ok, testCase.host)
+		assert.Equal(t, testCase.pattern, pattern, testCase.host)
+	}
+	pattern, ok := bestMatch([]string{"*.example.com", "?.example.com"}, "a.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "*.example.com", pattern)
+	_, ok = bestMatch([]string{".example.com"}, "other.org")
+	assert.False(t, ok)
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+// A HostEvent describes something the reconnx plugin observed or did
+// concerning a single host. HostEvents are delivered to a Listener.
+type HostEvent struct {
+	// Host is the host key identifying the host's Machine. It is empty
+	// for errors which occur before the host is known.
+	Host string
+
+	// Attempt is the zero-based attempt number within the execution
+	// which caused the event.
+	Attempt int
+
+	// Prev is the host Machine's state before the event.
+	Prev State
+
+	// Next is the host Machine's state after the event. For events
+	// which do not involve a state transition, Next is equal to Prev.
+	Next State
+
+	// Reason explains why the host Machine entered the Next state. For
+	// StateChanged events, it is the reason for the transition. For
+	// CloseRequested events, it is the reason the Machine entered the
+	// Closing state. Reason is ReasonNone if the Machine does not
+	// explain its state transitions.
+	//
+	// For CloseRequested events caused by a connection's own Machine in
+	// per-connection mode, Reason and the averages and counters below
+	// are the connection Machine's, while Prev and Next remain the host
+	// Machine's state.
+	Reason Reason
+
+	// RecentAvg is the host Machine's recent average after the event.
+	RecentAvg float64
+
+	// HistoricalAvg is the host Machine's historical average after the
+	// event.
+	HistoricalAvg float64
+
+	// ClosedStreak is the number of consecutive connections closed
+	// during the host Machine's current Closing period. For StateChanged
+	// events which end a Closing period, it is the streak which was
+	// reached, as in the Transition.
+	ClosedStreak uint
+
+	// ClosedCount is the total number of connections closed during the
+	// host Machine's current Closing period. For StateChanged events
+	// which end a Closing period, it is the count which was reached, as
+	// in the Transition.
+	ClosedCount uint
+
+	// Err is the internal error being reported. It is nil except for
+	// events delivered to Listener.Error, for which only the Host,
+	// Attempt, and Err fields are set.
+	Err error
+}
+
+// A Listener receives typed notifications about state transitions,
+// close decisions, and internal errors in the reconnx plugin. Unlike
+// the messages sent to the Logger, the notifications are structured
+// and are suitable for driving alerts and dashboards.
+//
+// Listener methods are called synchronously on the request path, so
+// implementations should return quickly. Implementations of Listener
+// must be safe for concurrent use by multiple goroutines.
+type Listener interface {
+	// StateChanged is called when a host's Machine transitions from
+	// one state to another.
+	StateChanged(evt HostEvent)
+
+	// CloseRequested is called when the plugin requests that the
+	// connection used by a request attempt be closed after the attempt
+	// ends.
+	CloseRequested(evt HostEvent)
+
+	// Error is called when the plugin encounters an internal error.
+	// The error is available in the Err field of the event.
+	Error(evt HostEvent)
+}
+
+// NopListener implements the Listener interface but ignores all
+// notifications sent to it.
+type NopListener struct{}
+
+func (NopListener) StateChanged(HostEvent) {
+}
+
+func (NopListener) CloseRequested(HostEvent) {
+}
+
+func (NopListener) Error(HostEvent) {
+}
+
+// transitionEvent returns the StateChanged event for a transition of a
+// host's Machine, taking the averages and counters from the Transition
+// so that they agree with the host's history.
+func transitionEvent(host string, attempt int, tr Transition) HostEvent {
+	return HostEvent{
+		Host:          host,
+		Attempt:       attempt,
+		Prev:          tr.Prev,
+		Next:          tr.Next,
+		Reason:        tr.Reason,
+		RecentAvg:     tr.RecentAvg,
+		HistoricalAvg: tr.HistoricalAvg,
+		ClosedStreak:  tr.ClosedStreak,
+		ClosedCount:   tr.ClosedCount,
+	}
+}
+
+func newHostEvent(host string, attempt int, prev, next State, stats MachineStats) HostEvent {
+	return HostEvent{
+		Host:          host,
+		Attempt:       attempt,
+		Prev:          prev,
+		Next:          next,
+		Reason:        stats.Reason,
+		RecentAvg:     stats.RecentAvg,
+		HistoricalAvg: stats.HistoricalAvg,
+		ClosedStreak:  stats.ClosedStreak,
+		ClosedCount:   stats.ClosedCount,
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNopListener(t *testing.T) {
+	l := NopListener{}
+	l.StateChanged(HostEvent{Host: "foo", Prev: Watching, Next: Closing})
+	l.CloseRequested(HostEvent{Host: "bar", Prev: Closing, Next: Closing})
+	l.Error(HostEvent{Err: errors.New("baz")})
+}
+
+func TestNewHostEvent(t *testing.T) {
+	evt := newHostEvent("foo", 3, Watching, Closing, MachineStats{
+		State:         Closing,
+		Reason:        ReasonAbsThreshold,
+		RecentAvg:     1.0,
+		HistoricalAvg: 2.0,
+		ClosedStreak:  3,
+		ClosedCount:   4,
+		RestCount:     5,
+	})
+
+	assert.Equal(t, HostEvent{
+		Host:          "foo",
+		Attempt:       3,
+		Prev:          Watching,
+		Next:          Closing,
+		Reason:        ReasonAbsThreshold,
+		RecentAvg:     1.0,
+		HistoricalAvg: 2.0,
+		ClosedStreak:  3,
+		ClosedCount:   4,
+	}, evt)
+}
+
+func TestTransitionEvent(t *testing.T) {
+	evt := transitionEvent("foo", 3, Transition{
+		Prev:          Closing,
+		Next:          Resting,
+		Reason:        ReasonClosingStreak,
+		RecentAvg:     1.0,
+		HistoricalAvg: 2.0,
+		ClosedStreak:  3,
+		ClosedCount:   4,
+	})
+
+	assert.Equal(t, HostEvent{
+		Host:          "foo",
+		Attempt:       3,
+		Prev:          Closing,
+		Next:          Resting,
+		Reason:        ReasonClosingStreak,
+		RecentAvg:     1.0,
+		HistoricalAvg: 2.0,
+		ClosedStreak:  3,
+		ClosedCount:   4,
+	}, evt)
+}
+
+type mockListener struct {
+	mock.Mock
+}
+
+func newMockListener(t *testing.T) *mockListener {
+	m := &mockListener{}
+	m.Test(t)
+	return m
+}
+
+func (m *mockListener) StateChanged(evt HostEvent) {
+	m.Called(evt)
+}
+
+func (m *mockListener) CloseRequested(evt HostEvent) {
+	m.Called(evt)
+}
+
+func (m *mockListener) Error(evt HostEvent) {
+	m.Called(evt)
+}
@@ -8,6 +8,9 @@ package reconnx
 // information about plugin operation. The interface is compatible with
 // the Go standard log.Logger.
 //
+// If the plugin is configured with a StructuredLogger, the
+// StructuredLogger is used instead of the Logger.
+//
 // Implementations of Logger must be safe for concurrent use by multiple
 // goroutines.
 type Logger interface {
@@ -23,3 +26,86 @@ type NopLogger struct{}
 
 func (NopLogger) Printf(string, ...interface{}) {
 }
+
+// A Level is the severity of a message sent to a StructuredLogger.
+type Level int
+
+const (
+	// LevelDebug is the level of routine, high-volume messages such as
+	// individual close decisions.
+	LevelDebug Level = iota
+
+	// LevelInfo is the level of interesting but expected events such as
+	// host state changes.
+	LevelInfo
+
+	// LevelWarn is the level of unexpected events which do not prevent
+	// the plugin from operating.
+	LevelWarn
+
+	// LevelError is the level of internal errors.
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return ""
+	}
+}
+
+// Keys of the fields the reconnx plugin attaches to messages sent to a
+// StructuredLogger.
+const (
+	// FieldHost is the key of the field holding the host key.
+	FieldHost = "host"
+
+	// FieldAttempt is the key of the field holding the zero-based
+	// attempt number.
+	FieldAttempt = "attempt"
+
+	// FieldState is the key of the field holding a host Machine's
+	// current State.
+	FieldState = "state"
+
+	// FieldPrevState is the key of the field holding a host Machine's
+	// previous State.
+	FieldPrevState = "prev_state"
+
+	// FieldReason is the key of the field holding the reason for an
+	// event, such as the Reason for a state transition or the
+	// description of an internal error.
+	FieldReason = "reason"
+
+	// FieldPeer is the key of the field holding the IP address of a
+	// peer of a host.
+	FieldPeer = "peer"
+)
+
+// A Field is a key/value pair attached to a message sent to a
+// StructuredLogger.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// StructuredLogger allows the reconnx plugin to log state changes and
+// other information about plugin operation as leveled messages with
+// key/value fields, so that they can be filtered and indexed.
+//
+// Implementations of StructuredLogger must be safe for concurrent use
+// by multiple goroutines.
+type StructuredLogger interface {
+	// Log sends a message with the given level and fields to the
+	// StructuredLogger. The message is a short, constant description
+	// of the event, and the details are carried in the fields.
+	Log(level Level, msg string, fields ...Field)
+}
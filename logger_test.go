@@ -7,6 +7,7 @@ package reconnx
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -33,3 +34,25 @@ func (m *mockLogger) Printf(f string, a ...interface{}) {
 		m.Called(f, a)
 	}
 }
+
+func TestLevel_String(t *testing.T) {
+	assert.Equal(t, "DEBUG", LevelDebug.String())
+	assert.Equal(t, "INFO", LevelInfo.String())
+	assert.Equal(t, "WARN", LevelWarn.String())
+	assert.Equal(t, "ERROR", LevelError.String())
+	assert.Equal(t, "", Level(-1).String())
+}
+
+type mockStructuredLogger struct {
+	mock.Mock
+}
+
+func newMockStructuredLogger(t *testing.T) *mockStructuredLogger {
+	m := &mockStructuredLogger{}
+	m.Test(t)
+	return m
+}
+
+func (m *mockStructuredLogger) Log(level Level, msg string, fields ...Field) {
+	m.Called(level, msg, fields)
+}
@@ -5,6 +5,7 @@
 package reconnx
 
 import (
+	"fmt"
 	"math"
 	"sync"
 )
@@ -56,6 +57,110 @@ func (s State) String() string {
 	}
 }
 
+// MarshalText implements the encoding.TextMarshaler interface. The
+// text form of a State is the same as its String value.
+func (s State) MarshalText() ([]byte, error) {
+	str := s.String()
+	if str == "" {
+		return nil, fmt.Errorf("reconnx: invalid state %d", int(s))
+	}
+	return []byte(str), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (s *State) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "Watching":
+		*s = Watching
+	case "Closing":
+		*s = Closing
+	case "Resting":
+		*s = Resting
+	default:
+		return fmt.Errorf("reconnx: invalid state %q", text)
+	}
+	return nil
+}
+
+// A Reason explains why a Machine entered its current state.
+type Reason int
+
+const (
+	// ReasonNone indicates that no particular reason applies, for
+	// example because the Machine has not yet left the initial Watching
+	// state.
+	ReasonNone Reason = iota
+
+	// ReasonAbsThreshold indicates that the Machine entered the Closing
+	// state because the recent average reached the absolute threshold.
+	ReasonAbsThreshold
+
+	// ReasonPctThreshold indicates that the Machine entered the Closing
+	// state because the recent average exceeded the historical average
+	// by at least the percentage threshold.
+	ReasonPctThreshold
+
+	// ReasonClosingStreak indicates that the Machine left the Closing
+	// state because the closed connection streak reached ClosingStreak.
+	ReasonClosingStreak
+
+	// ReasonClosingCount indicates that the Machine left the Closing
+	// state because the closed connection count reached ClosingCount.
+	ReasonClosingCount
+
+	// ReasonRested indicates that the Machine left the Resting state
+	// for the Watching state because the resting period is over and
+	// the data are good.
+	ReasonRested
+
+	// ReasonError indicates that a request attempt ended in an error.
+	// It is never the reason for a Machine's state, and is only used as
+	// the Trigger of a Decision.
+	ReasonError
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonNone:
+		return "None"
+	case ReasonAbsThreshold:
+		return "AbsThreshold"
+	case ReasonPctThreshold:
+		return "PctThreshold"
+	case ReasonClosingStreak:
+		return "ClosingStreak"
+	case ReasonClosingCount:
+		return "ClosingCount"
+	case ReasonRested:
+		return "Rested"
+	case ReasonError:
+		return "Error"
+	default:
+		return ""
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. The
+// text form of a Reason is the same as its String value.
+func (r Reason) MarshalText() ([]byte, error) {
+	str := r.String()
+	if str == "" {
+		return nil, fmt.Errorf("reconnx: invalid reason %d", int(r))
+	}
+	return []byte(str), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (r *Reason) UnmarshalText(text []byte) error {
+	for c := ReasonNone; c <= ReasonError; c++ {
+		if c.String() == string(text) {
+			*r = c
+			return nil
+		}
+	}
+	return fmt.Errorf("reconnx: invalid reason %q", text)
+}
+
 // A Machine is a simple generic state machine for deciding whether to
 // close connections.
 //
@@ -80,6 +185,116 @@ type Machine interface {
 	Next(value float64, closed bool) (next State, prev State)
 }
 
+// A Transition describes the outcome of receiving one data point into
+// a Machine, including why the Machine changed state, if it did.
+type Transition struct {
+	// Prev is the Machine's state before the data point was received.
+	Prev State
+
+	// Next is the Machine's state after the data point was received.
+	// If the data point did not cause a state transition, Next is equal
+	// to Prev.
+	Next State
+
+	// Reason explains why the Machine transitioned from Prev to Next.
+	// It is ReasonNone if there was no state transition.
+	Reason Reason
+
+	// RecentAvg is the recent average after the data point was
+	// received. This is the average compared against the thresholds.
+	RecentAvg float64
+
+	// HistoricalAvg is the historical average after the data point was
+	// received. This is the average the percentage threshold is
+	// relative to.
+	HistoricalAvg float64
+
+	// ClosedStreak is the number of consecutive connections closed
+	// during the Closing period, as of the data point. If the data
+	// point ended the Closing period, ClosedStreak is the streak which
+	// was reached, not the reset value.
+	ClosedStreak uint
+
+	// ClosedCount is the total number of connections closed during the
+	// Closing period, as of the data point. If the data point ended the
+	// Closing period, ClosedCount is the count which was reached, not
+	// the reset value.
+	ClosedCount uint
+
+	// RestCount is the number of data points received during the
+	// Resting period, as of the data point.
+	RestCount uint
+}
+
+// A DetailedMachine is a Machine which can explain its state
+// transitions. Machines constructed by NewMachine implement
+// DetailedMachine.
+type DetailedMachine interface {
+	Machine
+
+	// NextDetailed receives a new data point into the state machine in
+	// the same way as Next, and returns a Transition describing the
+	// result.
+	NextDetailed(value float64, closed bool) Transition
+}
+
+// nextTransition receives a new data point into sm, using the
+// NextDetailed method if sm is a DetailedMachine.
+func nextTransition(sm Machine, value float64, closed bool) Transition {
+	if dm, ok := sm.(DetailedMachine); ok {
+		return dm.NextDetailed(value, closed)
+	}
+
+	next, prev := sm.Next(value, closed)
+	return Transition{Prev: prev, Next: next}
+}
+
+// MachineStats is a snapshot of the internal statistics of a Machine.
+//
+// Machines constructed by NewMachine report their statistics through
+// a Stats method. The reconnx plugin uses the Stats method, if present,
+// to fill in the details of the events it reports.
+type MachineStats struct {
+	// State is the Machine's current state.
+	State State
+
+	// Reason explains why the Machine entered its current state.
+	Reason Reason
+
+	// RecentAvg is the average of the recent samples.
+	RecentAvg float64
+
+	// HistoricalAvg is the average of the historical samples.
+	HistoricalAvg float64
+
+	// ClosedStreak is the number of consecutive connections closed
+	// during the current Closing period.
+	ClosedStreak uint
+
+	// ClosedCount is the total number of connections closed during the
+	// current Closing period.
+	ClosedCount uint
+
+	// RestCount is the number of data points received during the
+	// current Resting period.
+	RestCount uint
+}
+
+type statsMachine interface {
+	Machine
+	Stats() MachineStats
+}
+
+// machineStats returns the statistics of sm if it reports them, or
+// just the given state if it does not.
+func machineStats(sm Machine, state State) MachineStats {
+	if ssm, ok := sm.(statsMachine); ok {
+		return ssm.Stats()
+	}
+
+	return MachineStats{State: state}
+}
+
 type avgWindow struct {
 	values []float64
 	sum    float64
@@ -117,6 +332,7 @@ func (aw *avgWindow) Push(value float64) (dropped float64) {
 
 type machine struct {
 	state        State
+	reason       Reason
 	historical   avgWindow
 	recent       avgWindow
 	closedStreak uint
@@ -234,25 +450,48 @@ func (m *machine) State() State {
 	return m.state
 }
 
+func (m *machine) Stats() MachineStats {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return MachineStats{
+		State:         m.state,
+		Reason:        m.reason,
+		RecentAvg:     m.recent.Avg(),
+		HistoricalAvg: m.historical.Avg(),
+		ClosedStreak:  m.closedStreak,
+		ClosedCount:   m.closedCount,
+		RestCount:     m.restCount,
+	}
+}
+
 func (m *machine) Next(value float64, closed bool) (next State, prev State) {
+	t := m.NextDetailed(value, closed)
+	return t.Next, t.Prev
+}
+
+func (m *machine) NextDetailed(value float64, closed bool) Transition {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	prev = m.state
+	t := Transition{Prev: m.state}
 	m.shift(value)
-	switch prev {
+	t.RecentAvg, t.HistoricalAvg = m.recent.Avg(), m.historical.Avg()
+	switch t.Prev {
 	case Watching:
-		m.watching()
+		m.watching(&t)
 	case Closing:
-		m.closing(closed)
+		m.closing(&t, closed)
 	case Resting:
-		m.resting()
+		m.resting(&t)
 	default:
 		panic("reconnx: unknown state")
 	}
 
-	next = m.state
-	return
+	t.Next = m.state
+	if t.Next != t.Prev {
+		t.Reason = m.reason
+	}
+	return t
 }
 
 func (m *machine) shift(value float64) {
@@ -263,27 +502,33 @@ func (m *machine) shift(value float64) {
 	m.historical.Push(dropped)
 }
 
-func (m *machine) watching() {
+func (m *machine) watching(t *Transition) {
 	recentAvg := m.recent.Avg()
 	if m.config.AbsThreshold > 0.0 && recentAvg >= m.config.AbsThreshold {
-		m.state = Closing
+		m.state, m.reason = Closing, ReasonAbsThreshold
 	} else if m.config.PctThreshold > 0.0 && recentAvg >= m.historical.Avg()*((100.0+m.config.PctThreshold)/100.0) {
-		m.state = Closing
+		m.state, m.reason = Closing, ReasonPctThreshold
 	}
 
 	if m.state == Closing {
-		m.closing(false)
+		m.closing(t, false)
 	}
 }
 
-func (m *machine) closing(closed bool) {
+func (m *machine) closing(t *Transition, closed bool) {
 	if closed {
 		m.closedCount++
 		m.closedStreak++
 	} else {
 		m.closedStreak = 0
 	}
+	t.ClosedStreak, t.ClosedCount = m.closedStreak, m.closedCount
 	if m.closedStreak >= m.config.ClosingStreak || m.closedCount >= m.config.ClosingCount {
+		if m.closedStreak >= m.config.ClosingStreak {
+			m.reason = ReasonClosingStreak
+		} else {
+			m.reason = ReasonClosingCount
+		}
 		m.closedCount = 0
 		m.closedStreak = 0
 		if m.config.RestingCount > 0 {
@@ -294,12 +539,13 @@ func (m *machine) closing(closed bool) {
 	}
 }
 
-func (m *machine) resting() {
+func (m *machine) resting(t *Transition) {
 	m.restCount++
+	t.RestCount = m.restCount
 	if m.restCount >= m.config.RestingCount {
 		m.restCount = 0
-		m.state = Watching
-		m.watching()
+		m.state, m.reason = Watching, ReasonRested
+		m.watching(t)
 	}
 }
 
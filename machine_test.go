@@ -28,6 +28,58 @@ func TestState_String(t *testing.T) {
 	})
 }
 
+func TestState_Text(t *testing.T) {
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, s := range []State{Watching, Closing, Resting} {
+			b, err := s.MarshalText()
+			require.NoError(t, err)
+			assert.Equal(t, s.String(), string(b))
+			var s2 State
+			err = s2.UnmarshalText(b)
+			require.NoError(t, err)
+			assert.Equal(t, s, s2)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := State(-1).MarshalText()
+		assert.EqualError(t, err, "reconnx: invalid state -1")
+		var s State
+		err = s.UnmarshalText([]byte("Sleeping"))
+		assert.EqualError(t, err, `reconnx: invalid state "Sleeping"`)
+	})
+}
+
+func TestReason(t *testing.T) {
+	t.Run("String", func(t *testing.T) {
+		assert.Equal(t, "None", ReasonNone.String())
+		assert.Equal(t, "AbsThreshold", ReasonAbsThreshold.String())
+		assert.Equal(t, "PctThreshold", ReasonPctThreshold.String())
+		assert.Equal(t, "ClosingStreak", ReasonClosingStreak.String())
+		assert.Equal(t, "ClosingCount", ReasonClosingCount.String())
+		assert.Equal(t, "Rested", ReasonRested.String())
+		assert.Equal(t, "Error", ReasonError.String())
+		assert.Equal(t, "", Reason(-1).String())
+	})
+	t.Run("MarshalText", func(t *testing.T) {
+		b, err := ReasonPctThreshold.MarshalText()
+		require.NoError(t, err)
+		assert.Equal(t, "PctThreshold", string(b))
+		_, err = Reason(-1).MarshalText()
+		assert.EqualError(t, err, "reconnx: invalid reason -1")
+	})
+	t.Run("UnmarshalText", func(t *testing.T) {
+		for r := ReasonNone; r <= ReasonError; r++ {
+			var r2 Reason
+			err := r2.UnmarshalText([]byte(r.String()))
+			require.NoError(t, err)
+			assert.Equal(t, r, r2)
+		}
+		var r Reason
+		err := r.UnmarshalText([]byte("Boredom"))
+		assert.EqualError(t, err, `reconnx: invalid reason "Boredom"`)
+	})
+}
+
 func TestAvgWindow(t *testing.T) {
 	t.Run("New", func(t *testing.T) {
 		t.Run("Zero.Len", func(t *testing.T) {
@@ -123,6 +175,88 @@ func TestMachine(t *testing.T) {
 			assert.Len(t, m2.recent.values, 22)
 		})
 	})
+	t.Run("Stats", func(t *testing.T) {
+		m := NewMachine(MachineConfig{
+			HistoricalSamples: 2,
+			RecentSamples:     1,
+			AbsThreshold:      10.0,
+			ClosingStreak:     3,
+			ClosingCount:      3,
+		})
+		require.Implements(t, (*statsMachine)(nil), m)
+		m.Next(10.0, false)
+		m.Next(4.0, true)
+
+		stats := m.(statsMachine).Stats()
+
+		assert.Equal(t, MachineStats{
+			State:         Closing,
+			Reason:        ReasonAbsThreshold,
+			RecentAvg:     4.0,
+			HistoricalAvg: 10.0,
+			ClosedStreak:  1,
+			ClosedCount:   1,
+		}, stats)
+		assert.Equal(t, stats, machineStats(m, Watching))
+		assert.Equal(t, MachineStats{State: Resting}, machineStats(newMockMachine(t), Resting))
+	})
+	t.Run("Reason", func(t *testing.T) {
+		m := NewMachine(MachineConfig{
+			HistoricalSamples: 1,
+			RecentSamples:     1,
+			PctThreshold:      50.0,
+			ClosingStreak:     1,
+			ClosingCount:      1,
+		})
+		m.Next(10.0, false)
+		m.Next(15.0, false)
+
+		assert.Equal(t, Closing, m.State())
+		assert.Equal(t, ReasonPctThreshold, machineStats(m, Closing).Reason)
+
+		m.Next(10.0, true)
+
+		assert.Equal(t, Watching, m.State())
+		assert.Equal(t, ReasonClosingStreak, machineStats(m, Watching).Reason)
+	})
+	t.Run("NextDetailed", func(t *testing.T) {
+		m := NewMachine(MachineConfig{
+			HistoricalSamples: 2,
+			RecentSamples:     1,
+			AbsThreshold:      10.0,
+			PctThreshold:      100.0,
+			ClosingStreak:     2,
+			ClosingCount:      3,
+			RestingCount:      1,
+		})
+		require.Implements(t, (*DetailedMachine)(nil), m)
+		dm := m.(DetailedMachine)
+
+		assert.Equal(t, Transition{Prev: Watching, Next: Watching, RecentAvg: 1.0, HistoricalAvg: 10.0}, dm.NextDetailed(1.0, false))
+		assert.Equal(t, Transition{Prev: Watching, Next: Watching, RecentAvg: 1.0, HistoricalAvg: 5.5}, dm.NextDetailed(1.0, false))
+		assert.Equal(t, Transition{Prev: Watching, Next: Closing, Reason: ReasonPctThreshold, RecentAvg: 3.0, HistoricalAvg: 1.0}, dm.NextDetailed(3.0, false))
+		assert.Equal(t, Transition{Prev: Closing, Next: Closing, RecentAvg: 3.0, HistoricalAvg: 2.0, ClosedStreak: 1, ClosedCount: 1}, dm.NextDetailed(3.0, true))
+		assert.Equal(t, Transition{Prev: Closing, Next: Closing, RecentAvg: 3.0, HistoricalAvg: 3.0, ClosedCount: 1}, dm.NextDetailed(3.0, false))
+		assert.Equal(t, Transition{Prev: Closing, Next: Closing, RecentAvg: 3.0, HistoricalAvg: 3.0, ClosedStreak: 1, ClosedCount: 2}, dm.NextDetailed(3.0, true))
+		assert.Equal(t, Transition{Prev: Closing, Next: Resting, Reason: ReasonClosingStreak, RecentAvg: 3.0, HistoricalAvg: 3.0, ClosedStreak: 2, ClosedCount: 3}, dm.NextDetailed(3.0, true))
+		assert.Equal(t, Transition{Prev: Resting, Next: Watching, Reason: ReasonRested, RecentAvg: 3.0, HistoricalAvg: 3.0, RestCount: 1}, dm.NextDetailed(3.0, false))
+		assert.Equal(t, Transition{Prev: Watching, Next: Closing, Reason: ReasonAbsThreshold, RecentAvg: 10.0, HistoricalAvg: 3.0}, dm.NextDetailed(20.0, false))
+		assert.Equal(t, Transition{Prev: Closing, Next: Closing, RecentAvg: 1.0, HistoricalAvg: 6.5, ClosedStreak: 1, ClosedCount: 1}, dm.NextDetailed(1.0, true))
+		assert.Equal(t, Transition{Prev: Closing, Next: Closing, RecentAvg: 1.0, HistoricalAvg: 5.5, ClosedCount: 1}, dm.NextDetailed(1.0, false))
+		assert.Equal(t, Transition{Prev: Closing, Next: Closing, RecentAvg: 1.0, HistoricalAvg: 1.0, ClosedStreak: 1, ClosedCount: 2}, dm.NextDetailed(1.0, true))
+		assert.Equal(t, Transition{Prev: Closing, Next: Closing, RecentAvg: 1.0, HistoricalAvg: 1.0, ClosedCount: 2}, dm.NextDetailed(1.0, false))
+		assert.Equal(t, Transition{Prev: Closing, Next: Resting, Reason: ReasonClosingCount, RecentAvg: 1.0, HistoricalAvg: 1.0, ClosedStreak: 1, ClosedCount: 3}, dm.NextDetailed(1.0, true))
+		assert.Equal(t, Resting, m.State())
+	})
+	t.Run("nextTransition", func(t *testing.T) {
+		m := newMockMachine(t)
+		m.On("Next", 2.0, true).Return(Resting, Closing).Once()
+
+		tr := nextTransition(m, 2.0, true)
+
+		m.AssertExpectations(t)
+		assert.Equal(t, Transition{Prev: Closing, Next: Resting}, tr)
+	})
 	t.Run("NextAndState", func(t *testing.T) {
 		type testStep struct {
 			value  float64
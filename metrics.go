@@ -0,0 +1,128 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+// Names of the metrics the reconnx plugin reports to Metrics.
+const (
+	// MetricAttempts is the name of the counter incremented for every
+	// request attempt observed. It is tagged with TagHost and TagState,
+	// the latter holding the host Machine's state after the attempt.
+	MetricAttempts = "reconnx.attempts"
+
+	// MetricLatency is the name of the histogram receiving the latency
+	// of every request attempt in milliseconds. It is tagged with
+	// TagHost.
+	MetricLatency = "reconnx.latency_ms"
+
+	// MetricRecentAvg is the name of the gauge set to a host Machine's
+	// recent average after every request attempt. It is tagged with
+	// TagHost.
+	MetricRecentAvg = "reconnx.recent_avg_ms"
+
+	// MetricHistoricalAvg is the name of the gauge set to a host
+	// Machine's historical average after every request attempt. It is
+	// tagged with TagHost.
+	MetricHistoricalAvg = "reconnx.historical_avg_ms"
+
+	// MetricCloseRequests is the name of the counter incremented every
+	// time the plugin requests that a connection be closed. It is
+	// tagged with TagHost.
+	MetricCloseRequests = "reconnx.close_requests"
+
+	// MetricTransitions is the name of the counter incremented every
+	// time a host Machine changes state. It is tagged with TagHost,
+	// TagFrom, and TagTo.
+	MetricTransitions = "reconnx.transitions"
+
+	// MetricRecycles is the name of the counter incremented every time
+	// the plugin recycles a connection because it exceeded a limit in
+	// the RecycleConfig, because the DNS watcher found that its peer is
+	// no longer in the DNS answer, or because its peer is an ejected
+	// outlier. It is tagged with TagHost and TagCause.
+	MetricRecycles = "reconnx.recycles"
+
+	// MetricEjections is the name of the counter incremented every
+	// time a peer of a host is ejected as an outlier. It is tagged with
+	// TagHost.
+	MetricEjections = "reconnx.ejections"
+
+	// MetricDrains is the name of the counter incremented every time a
+	// drain of a host's connections completes. It is tagged with
+	// TagHost.
+	MetricDrains = "reconnx.drains"
+
+	// MetricEvictions is the name of the counter incremented every time
+	// the plugin evicts a host to stay within the Config's MaxHosts, or
+	// because the host was idle for longer than HostIdleTTL. It is
+	// tagged with TagHost and TagCause.
+	MetricEvictions = "reconnx.evictions"
+
+	// MetricErrors is the name of the counter incremented every time
+	// the plugin encounters an internal error. It is not tagged.
+	MetricErrors = "reconnx.errors"
+)
+
+// Keys of the tags the reconnx plugin attaches to metrics.
+const (
+	// TagHost is the key of the tag holding the host key.
+	TagHost = "host"
+
+	// TagState is the key of the tag holding a host Machine's State.
+	TagState = "state"
+
+	// TagFrom is the key of the tag holding the State a host Machine
+	// transitioned from.
+	TagFrom = "from"
+
+	// TagTo is the key of the tag holding the State a host Machine
+	// transitioned to.
+	TagTo = "to"
+
+	// TagCause is the key of the tag holding the cause of a connection
+	// being recycled, one of "max_age", "max_requests", "dns" or
+	// "outlier", or of a host being evicted, one of "max_hosts" or
+	// "idle".
+	TagCause = "cause"
+)
+
+// A Tag is a key/value pair which, together with the metric name,
+// identifies a metric reported to Metrics.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Metrics allows the reconnx plugin to report telemetry to an arbitrary
+// metrics backend, without depending on any particular metrics library.
+// Package metrics contains an in-memory implementation suitable for
+// tests and an implementation backed by the standard expvar package.
+//
+// Metrics methods are called synchronously on the request path, so
+// implementations should return quickly. Implementations of Metrics
+// must be safe for concurrent use by multiple goroutines.
+type Metrics interface {
+	// Counter adds delta to the counter identified by name and tags.
+	Counter(name string, delta int64, tags ...Tag)
+
+	// Gauge sets the gauge identified by name and tags to value.
+	Gauge(name string, value float64, tags ...Tag)
+
+	// Histogram records value as an observation in the histogram
+	// identified by name and tags.
+	Histogram(name string, value float64, tags ...Tag)
+}
+
+// NopMetrics implements the Metrics interface but ignores all metrics
+// sent to it.
+type NopMetrics struct{}
+
+func (NopMetrics) Counter(string, int64, ...Tag) {
+}
+
+func (NopMetrics) Gauge(string, float64, ...Tag) {
+}
+
+func (NopMetrics) Histogram(string, float64, ...Tag) {
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"expvar"
+	"math"
+	"sync"
+
+	"github.com/gogama/reconnx"
+)
+
+// Expvar is a reconnx.Metrics implementation which publishes metrics
+// through the standard library's expvar package, and thus on the
+// /debug/vars endpoint of the default HTTP server mux.
+//
+// All metrics are published under a single expvar.Map. Within the map,
+// each metric is keyed by the result of Key. Counters are expvar.Int
+// values and gauges are expvar.Float values. Histograms are summarized
+// as an expvar.Map holding the count, sum, min and max of the
+// observations.
+type Expvar struct {
+	m    *expvar.Map
+	lock sync.Mutex
+}
+
+// NewExpvar creates an Expvar which publishes its metrics under name.
+// Like expvar.Publish, NewExpvar panics if name is already in use.
+func NewExpvar(name string) *Expvar {
+	return &Expvar{m: expvar.NewMap(name)}
+}
+
+// Map returns the expvar.Map under which the metrics are published.
+func (e *Expvar) Map() *expvar.Map {
+	return e.m
+}
+
+// Counter adds delta to the counter identified by name and tags.
+func (e *Expvar) Counter(name string, delta int64, tags ...reconnx.Tag) {
+	e.m.Add(Key(name, tags...), delta)
+}
+
+// Gauge sets the gauge identified by name and tags to value.
+func (e *Expvar) Gauge(name string, value float64, tags ...reconnx.Tag) {
+	key := Key(name, tags...)
+	f, ok := e.m.Get(key).(*expvar.Float)
+	if !ok {
+		e.lock.Lock()
+		if f, ok = e.m.Get(key).(*expvar.Float); !ok {
+			f = new(expvar.Float)
+			e.m.Set(key, f)
+		}
+		e.lock.Unlock()
+	}
+	f.Set(value)
+}
+
+// Histogram adds value to the summary of the histogram identified by
+// name and tags.
+func (e *Expvar) Histogram(name string, value float64, tags ...reconnx.Tag) {
+	key := Key(name, tags...)
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	h, ok := e.m.Get(key).(*expvar.Map)
+	if !ok {
+		h = new(expvar.Map).Init()
+		e.m.Set(key, h)
+	}
+	h.Add("count", 1)
+	h.AddFloat("sum", value)
+	setFloat(h, "min", value, math.Min)
+	setFloat(h, "max", value, math.Max)
+}
+
+func setFloat(m *expvar.Map, key string, value float64, pick func(float64, float64) float64) {
+	f, ok := m.Get(key).(*expvar.Float)
+	if !ok {
+		f = new(expvar.Float)
+		f.Set(value)
+		m.Set(key, f)
+		return
+	}
+	f.Set(pick(f.Value(), value))
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gogama/reconnx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expvarRuns numbers the runs of TestExpvar, since expvar names can
+// only be published once per process, and go test -count reruns tests
+// in the same process.
+var expvarRuns int32
+
+func TestExpvar(t *testing.T) {
+	name := fmt.Sprintf("reconnx_test_expvar_%d", atomic.AddInt32(&expvarRuns, 1))
+	e := NewExpvar(name)
+	host := reconnx.Tag{Key: reconnx.TagHost, Value: "foo"}
+
+	e.Counter("c", 1, host)
+	e.Counter("c", 2, host)
+	e.Gauge("g", 1.5, host)
+	e.Gauge("g", 0.5, host)
+	e.Histogram("h", 2.0, host)
+	e.Histogram("h", 1.0, host)
+	e.Histogram("h", 3.0, host)
+
+	assert.Same(t, e.Map(), expvar.Get(name))
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(e.Map().String()), &m))
+	assert.Equal(t, map[string]interface{}{
+		"c{host=foo}": 3.0,
+		"g{host=foo}": 0.5,
+		"h{host=foo}": map[string]interface{}{
+			"count": 3.0,
+			"sum":   6.0,
+			"min":   1.0,
+			"max":   3.0,
+		},
+	}, m)
+	assert.Panics(t, func() {
+		NewExpvar(name)
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gogama/reconnx"
+)
+
+// Memory is a reconnx.Metrics implementation which keeps all metrics in
+// memory. It is mainly intended for use in tests. The zero value is
+// ready to use.
+type Memory struct {
+	lock       sync.Mutex
+	counters   map[string]int64
+	gauges     map[string]float64
+	histograms map[string][]float64
+}
+
+// Counter adds delta to the counter identified by name and tags.
+func (m *Memory) Counter(name string, delta int64, tags ...reconnx.Tag) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counters == nil {
+		m.counters = map[string]int64{}
+	}
+	m.counters[Key(name, tags...)] += delta
+}
+
+// Gauge sets the gauge identified by name and tags to value.
+func (m *Memory) Gauge(name string, value float64, tags ...reconnx.Tag) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.gauges == nil {
+		m.gauges = map[string]float64{}
+	}
+	m.gauges[Key(name, tags...)] = value
+}
+
+// Histogram appends value to the observations recorded in the
+// histogram identified by name and tags.
+func (m *Memory) Histogram(name string, value float64, tags ...reconnx.Tag) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.histograms == nil {
+		m.histograms = map[string][]float64{}
+	}
+	key := Key(name, tags...)
+	m.histograms[key] = append(m.histograms[key], value)
+}
+
+// CounterValue returns the current value of the counter identified by
+// name and tags, or zero if the counter does not exist.
+func (m *Memory) CounterValue(name string, tags ...reconnx.Tag) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.counters[Key(name, tags...)]
+}
+
+// GaugeValue returns the current value of the gauge identified by name
+// and tags. The second return value is false if the gauge has never
+// been set.
+func (m *Memory) GaugeValue(name string, tags ...reconnx.Tag) (float64, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.gauges[Key(name, tags...)]
+	return v, ok
+}
+
+// HistogramValues returns a copy of the observations recorded in the
+// histogram identified by name and tags.
+func (m *Memory) HistogramValues(name string, tags ...reconnx.Tag) []float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	values := m.histograms[Key(name, tags...)]
+	if values == nil {
+		return nil
+	}
+	return append([]float64(nil), values...)
+}
+
+// Keys returns the keys, as produced by Key, of every metric recorded,
+// in sorted order.
+func (m *Memory) Keys() []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var keys []string
+	for k := range m.counters {
+		keys = append(keys, k)
+	}
+	for k := range m.gauges {
+		keys = append(keys, k)
+	}
+	for k := range m.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Reset discards all recorded metrics.
+func (m *Memory) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.counters, m.gauges, m.histograms = nil, nil, nil
+}
+
+// Key returns a string which uniquely identifies the metric with the
+// given name and tags, regardless of tag order. The key has the form
+// "name{k1=v1,k2=v2}", with tags sorted by key, or just "name" if
+// there are no tags.
+func Key(name string, tags ...reconnx.Tag) string {
+	if len(tags) == 0 {
+		return name
+	}
+
+	sorted := make([]reconnx.Tag, len(tags))
+	copy(sorted, tags)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, tag := range sorted {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(tag.Key)
+		b.WriteByte('=')
+		b.WriteString(tag.Value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gogama/httpx"
+	"github.com/gogama/reconnx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	t.Run("Zero", func(t *testing.T) {
+		var m Memory
+
+		assert.Equal(t, int64(0), m.CounterValue("foo"))
+		_, ok := m.GaugeValue("foo")
+		assert.False(t, ok)
+		assert.Nil(t, m.HistogramValues("foo"))
+		assert.Empty(t, m.Keys())
+	})
+	t.Run("Record", func(t *testing.T) {
+		var m Memory
+		a, b := reconnx.Tag{Key: "a", Value: "1"}, reconnx.Tag{Key: "b", Value: "2"}
+
+		m.Counter("c", 1, a, b)
+		m.Counter("c", 2, b, a)
+		m.Counter("c", 5)
+		m.Gauge("g", 1.5, a)
+		m.Gauge("g", 2.5, a)
+		m.Histogram("h", 1.0)
+		m.Histogram("h", 3.0)
+
+		assert.Equal(t, int64(3), m.CounterValue("c", a, b))
+		assert.Equal(t, int64(5), m.CounterValue("c"))
+		v, ok := m.GaugeValue("g", a)
+		assert.True(t, ok)
+		assert.Equal(t, 2.5, v)
+		values := m.HistogramValues("h")
+		assert.Equal(t, []float64{1.0, 3.0}, values)
+		values[0] = 99.0
+		assert.Equal(t, []float64{1.0, 3.0}, m.HistogramValues("h"))
+		assert.Equal(t, []string{"c", "c{a=1,b=2}", "g{a=1}", "h"}, m.Keys())
+
+		m.Reset()
+
+		assert.Empty(t, m.Keys())
+	})
+	t.Run("Plugin", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(2 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		m := &Memory{}
+		cl := &httpx.Client{}
+		reconnx.OnClient(cl, reconnx.Config{
+			Metrics: m,
+			Latency: reconnx.MachineConfig{
+				AbsThreshold:  1.0,
+				ClosingStreak: 1,
+				ClosingCount:  1,
+			},
+		})
+
+		_, err = cl.Get(server.URL)
+		require.NoError(t, err)
+		_, err = cl.Get(server.URL)
+		require.NoError(t, err)
+
+		host := reconnx.Tag{Key: reconnx.TagHost, Value: u.Host}
+		assert.Equal(t, int64(1), m.CounterValue(reconnx.MetricAttempts, host, reconnx.Tag{Key: reconnx.TagState, Value: "Closing"}))
+		assert.Equal(t, int64(1), m.CounterValue(reconnx.MetricAttempts, host, reconnx.Tag{Key: reconnx.TagState, Value: "Watching"}))
+		assert.Equal(t, int64(1), m.CounterValue(reconnx.MetricCloseRequests, host))
+		assert.Equal(t, int64(1), m.CounterValue(reconnx.MetricTransitions, host,
+			reconnx.Tag{Key: reconnx.TagFrom, Value: "Watching"}, reconnx.Tag{Key: reconnx.TagTo, Value: "Closing"}))
+		assert.Equal(t, int64(1), m.CounterValue(reconnx.MetricTransitions, host,
+			reconnx.Tag{Key: reconnx.TagFrom, Value: "Closing"}, reconnx.Tag{Key: reconnx.TagTo, Value: "Watching"}))
+		assert.Len(t, m.HistogramValues(reconnx.MetricLatency, host), 2)
+		v, ok := m.GaugeValue(reconnx.MetricRecentAvg, host)
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, v)
+		_, ok = m.GaugeValue(reconnx.MetricHistoricalAvg, host)
+		assert.True(t, ok)
+		assert.Equal(t, int64(0), m.CounterValue(reconnx.MetricErrors))
+	})
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "foo", Key("foo"))
+	assert.Equal(t, "foo{a=1,b=2,c=3}", Key("foo",
+		reconnx.Tag{Key: "c", Value: "3"},
+		reconnx.Tag{Key: "a", Value: "1"},
+		reconnx.Tag{Key: "b", Value: "2"}))
+}
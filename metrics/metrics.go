@@ -0,0 +1,234 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package metrics contains tools for getting telemetry out of the reconnx
+plugin without depending on any metrics library.
+
+The Memory and Expvar types implement the reconnx.Metrics interface.
+Memory keeps metrics in memory for use in tests, while Expvar publishes
+them through the standard expvar package:
+
+	cfg := reconnx.Config{
+		Metrics: metrics.NewExpvar("reconnx"),
+	}
+
+Separately, the Handler function exposes the counters and gauges kept
+inside an installed reconnx plugin in the Prometheus text exposition
+format.
+
+Mount the handler wherever your Prometheus server scrapes:
+
+	p := reconnx.Install(client, cfg)
+	mux.Handle("/metrics/reconnx", metrics.Handler(p, metrics.Options{}))
+
+The following metrics are exposed:
+
+	reconnx_attempts_total                      counter
+	reconnx_close_requests_total                counter
+	reconnx_errors_total                        counter
+	reconnx_evictions_total                     counter
+	reconnx_state_transitions_total{from,to}    counter
+	reconnx_hosts                               gauge
+	reconnx_host_state{host,state}              gauge (1 for the current state, else 0)
+	reconnx_host_recent_avg_ms{host}            gauge
+	reconnx_host_historical_avg_ms{host}        gauge
+	reconnx_host_series_omitted                 gauge
+
+To bound label cardinality, per-host gauges are only rendered for at
+most Options.MaxHosts hosts. Hosts which are not Watching are preferred,
+followed by the hosts with the most attempts. The number of hosts left
+out is reported by reconnx_host_series_omitted.
+*/
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gogama/reconnx"
+)
+
+// DefaultMaxHosts is the default maximum number of hosts for which
+// per-host gauges are rendered, used if the MaxHosts field of Options
+// is zero.
+const DefaultMaxHosts = 100
+
+// ContentType is the content type of the Prometheus text exposition
+// format rendered by the handler.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Options specifies how to render metrics.
+type Options struct {
+	// MaxHosts is the maximum number of hosts for which per-host gauges
+	// are rendered. If zero, DefaultMaxHosts is used. If negative, no
+	// per-host gauges are rendered.
+	MaxHosts int
+}
+
+var states = []reconnx.State{reconnx.Watching, reconnx.Closing, reconnx.Resting}
+
+// Handler returns an http.Handler which renders the metrics kept by p
+// in the Prometheus text exposition format.
+func Handler(p *reconnx.Plugin, opts Options) http.Handler {
+	if p == nil {
+		panic("reconnx/metrics: nil plugin")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = Write(w, p, opts)
+	})
+}
+
+// Write renders the metrics kept by p to w in the Prometheus text
+// exposition format.
+func Write(w io.Writer, p *reconnx.Plugin, opts Options) error {
+	maxHosts := opts.MaxHosts
+	if maxHosts == 0 {
+		maxHosts = DefaultMaxHosts
+	} else if maxHosts < 0 {
+		maxHosts = 0
+	}
+
+	c := p.Counters()
+	snapshot := p.Snapshot()
+	hosts := selectHosts(snapshot, maxHosts)
+
+	bw := bufio.NewWriter(w)
+	e := encoder{w: bw}
+
+	e.header("reconnx_attempts_total", "counter", "Request attempts observed.")
+	e.sample("reconnx_attempts_total", nil, float64(c.Attempts))
+	e.header("reconnx_close_requests_total", "counter", "Request attempts whose connection reconnx requested to close.")
+	e.sample("reconnx_close_requests_total", nil, float64(c.CloseRequests))
+	e.header("reconnx_errors_total", "counter", "Internal errors encountered.")
+	e.sample("reconnx_errors_total", nil, float64(c.Errors))
+	e.header("reconnx_evictions_total", "counter", "Hosts evicted from the plugin's host table.")
+	e.sample("reconnx_evictions_total", nil, float64(c.Evictions))
+	e.header("reconnx_state_transitions_total", "counter", "Host state machine transitions.")
+	for _, from := range states {
+		for _, to := range states {
+			if from != to {
+				e.sample("reconnx_state_transitions_total",
+					[]label{{"from", from.String()}, {"to", to.String()}},
+					float64(c.Transitions[reconnx.StateChange{From: from, To: to}]))
+			}
+		}
+	}
+
+	e.header("reconnx_hosts", "gauge", "Hosts tracked.")
+	e.sample("reconnx_hosts", nil, float64(len(snapshot)))
+	e.header("reconnx_host_state", "gauge", "Current state of the host's state machine.")
+	for i := range hosts {
+		for _, s := range states {
+			v := 0.0
+			if hosts[i].State == s {
+				v = 1.0
+			}
+			e.sample("reconnx_host_state", []label{{"host", hosts[i].Host}, {"state", s.String()}}, v)
+		}
+	}
+	e.header("reconnx_host_recent_avg_ms", "gauge", "Recent average latency of the host in milliseconds.")
+	for i := range hosts {
+		e.sample("reconnx_host_recent_avg_ms", []label{{"host", hosts[i].Host}}, hosts[i].RecentAvg)
+	}
+	e.header("reconnx_host_historical_avg_ms", "gauge", "Historical average latency of the host in milliseconds.")
+	for i := range hosts {
+		e.sample("reconnx_host_historical_avg_ms", []label{{"host", hosts[i].Host}}, hosts[i].HistoricalAvg)
+	}
+	e.header("reconnx_host_series_omitted", "gauge", "Hosts omitted from per-host gauges to bound label cardinality.")
+	e.sample("reconnx_host_series_omitted", nil, float64(len(snapshot)-len(hosts)))
+
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+// selectHosts picks at most max hosts for per-host gauges, preferring
+// hosts which are not Watching, then hosts with the most attempts. The
+// result is ordered by host key.
+func selectHosts(snapshot []reconnx.HostStats, max int) []reconnx.HostStats {
+	if len(snapshot) <= max {
+		return snapshot
+	}
+
+	hosts := make([]reconnx.HostStats, len(snapshot))
+	copy(hosts, snapshot)
+	sort.SliceStable(hosts, func(i, j int) bool {
+		wi, wj := hosts[i].State == reconnx.Watching, hosts[j].State == reconnx.Watching
+		if wi != wj {
+			return wj
+		}
+		return hosts[i].Attempts > hosts[j].Attempts
+	})
+	hosts = hosts[:max]
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Host < hosts[j].Host
+	})
+	return hosts
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) header(name, typ, help string) {
+	e.write("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+}
+
+func (e *encoder) sample(name string, labels []label, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.name)
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(l.value))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+	e.write(b.String())
+}
+
+func (e *encoder) write(s string) {
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogama/httpx"
+	"github.com/gogama/reconnx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	t.Run("NilPlugin", func(t *testing.T) {
+		assert.PanicsWithValue(t, "reconnx/metrics: nil plugin", func() {
+			Handler(nil, Options{})
+		})
+	})
+	t.Run("Render", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(2 * time.Millisecond)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		cl := &httpx.Client{}
+		p := reconnx.Install(cl, reconnx.Config{
+			Latency: reconnx.MachineConfig{
+				AbsThreshold:  1.0,
+				ClosingStreak: 1,
+				ClosingCount:  1,
+			},
+		})
+		_, err = cl.Get(server.URL)
+		require.NoError(t, err)
+		_, err = cl.Get(server.URL)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		Handler(p, Options{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+		body := w.Body.String()
+		for _, line := range []string{
+			"# TYPE reconnx_attempts_total counter",
+			"reconnx_attempts_total 2",
+			"reconnx_close_requests_total 1",
+			"reconnx_errors_total 0",
+			"# TYPE reconnx_evictions_total counter",
+			"reconnx_evictions_total 0",
+			`reconnx_state_transitions_total{from="Watching",to="Closing"} 1`,
+			`reconnx_state_transitions_total{from="Closing",to="Watching"} 1`,
+			`reconnx_state_transitions_total{from="Resting",to="Watching"} 0`,
+			"# TYPE reconnx_hosts gauge",
+			"reconnx_hosts 1",
+			`reconnx_host_state{host="` + u.Host + `",state="Watching"} 1`,
+			`reconnx_host_state{host="` + u.Host + `",state="Closing"} 0`,
+			`reconnx_host_recent_avg_ms{host="` + u.Host + `"} 1`,
+			`reconnx_host_historical_avg_ms{host="` + u.Host + `"} 1`,
+			"reconnx_host_series_omitted 0",
+		} {
+			assert.Contains(t, body, line+"\n")
+		}
+	})
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("NoHosts", func(t *testing.T) {
+		p := reconnx.InstallHandlers(&httpx.HandlerGroup{}, reconnx.Config{})
+		var b strings.Builder
+
+		err := Write(&b, p, Options{MaxHosts: -1})
+
+		require.NoError(t, err)
+		assert.Contains(t, b.String(), "reconnx_hosts 0\n")
+		assert.NotContains(t, b.String(), "reconnx_host_state{")
+	})
+	t.Run("Evictions", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		cl := &httpx.Client{}
+		p := reconnx.Install(cl, reconnx.Config{MaxHosts: 1})
+		_, err := cl.Get(server.URL)
+		require.NoError(t, err)
+		_, err = cl.Get(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+		require.NoError(t, err)
+		var b strings.Builder
+
+		err = Write(&b, p, Options{})
+
+		require.NoError(t, err)
+		assert.Contains(t, b.String(), "reconnx_evictions_total 1\n")
+		assert.Contains(t, b.String(), "reconnx_hosts 1\n")
+	})
+	t.Run("WriteError", func(t *testing.T) {
+		p := reconnx.InstallHandlers(&httpx.HandlerGroup{}, reconnx.Config{})
+
+		err := Write(errWriter{}, p, Options{})
+
+		assert.EqualError(t, err, "write failed")
+	})
+}
+
+func TestSelectHosts(t *testing.T) {
+	snapshot := []reconnx.HostStats{
+		{Host: "a", Attempts: 1},
+		{Host: "b", Attempts: 50},
+		{Host: "c", Attempts: 2, MachineStats: reconnx.MachineStats{State: reconnx.Resting}},
+		{Host: "d", Attempts: 10},
+		{Host: "e", Attempts: 3, MachineStats: reconnx.MachineStats{State: reconnx.Closing}},
+	}
+
+	assert.Equal(t, snapshot, selectHosts(snapshot, 5))
+	assert.Equal(t, []reconnx.HostStats{snapshot[1], snapshot[2], snapshot[4]}, selectHosts(snapshot, 3))
+	assert.Empty(t, selectHosts(snapshot, 0))
+}
+
+func TestEncoder(t *testing.T) {
+	var b strings.Builder
+	e := encoder{w: bufio.NewWriter(&b)}
+
+	e.header("foo", "gauge", "Foo help.")
+	e.sample("foo", []label{{"host", "a\"b\\c\nd"}, {"x", "y"}}, 1.5)
+	e.sample("foo", nil, math.NaN())
+	e.sample("foo", nil, math.Inf(1))
+	e.sample("foo", nil, math.Inf(-1))
+	require.NoError(t, e.err)
+	require.NoError(t, e.w.Flush())
+
+	assert.Equal(t, `# HELP foo Foo help.
+# TYPE foo gauge
+foo{host="a\"b\\c\nd",x="y"} 1.5
+foo NaN
+foo +Inf
+foo -Inf
+`, b.String())
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
@@ -0,0 +1,40 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNopMetrics(t *testing.T) {
+	m := NopMetrics{}
+	m.Counter("foo", 1)
+	m.Gauge("bar", 2.0, Tag{"baz", "qux"})
+	m.Histogram("baz", 3.0, Tag{"a", "b"}, Tag{"c", "d"})
+}
+
+type mockMetrics struct {
+	mock.Mock
+}
+
+func newMockMetrics(t *testing.T) *mockMetrics {
+	m := &mockMetrics{}
+	m.Test(t)
+	return m
+}
+
+func (m *mockMetrics) Counter(name string, delta int64, tags ...Tag) {
+	m.Called(name, delta, tags)
+}
+
+func (m *mockMetrics) Gauge(name string, value float64, tags ...Tag) {
+	m.Called(name, value, tags)
+}
+
+func (m *mockMetrics) Histogram(name string, value float64, tags ...Tag) {
+	m.Called(name, value, tags)
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOutlierMaxEjectedFraction is the default maximum fraction
+	// of the peers of a host name which may be ejected at once if the
+	// MaxEjectedFraction field of an OutlierConfig is zero.
+	DefaultOutlierMaxEjectedFraction = 0.5
+
+	// DefaultOutlierMinPeers is the default minimum number of peers
+	// needed for outlier detection if the MinPeers field of an
+	// OutlierConfig is zero.
+	DefaultOutlierMinPeers = 3
+)
+
+// An OutlierConfig specifies how the reconnx plugin detects peers which
+// are much slower than their siblings.
+//
+// A peer is one IP address of a host name, as seen in the remote
+// addresses of the connections to the host. When outlier detection is
+// enabled, the plugin keeps a recent latency average for each peer,
+// and compares it to the median of the recent averages of the host
+// name's other peers. A peer whose recent average exceeds that median
+// by at least Ratio is ejected: its connections are recycled the next
+// time they are used, in the same way as connections which exceed a
+// limit in the RecycleConfig. If the Config also has a Pool, the idle
+// connections to the host in the Pool are closed as soon as a peer is
+// ejected.
+//
+// Unlike the absolute and percentage thresholds of a MachineConfig,
+// outlier detection still spots a slow peer when the latency of every
+// peer is drifting together.
+//
+// A peer stays ejected until a later sample brings its recent average
+// back under the threshold.
+type OutlierConfig struct {
+	// Ratio is the ratio of a peer's recent average to the median of
+	// the recent averages of the other peers at or above which the peer
+	// is an outlier. For example, 3.0 ejects peers which are three
+	// times slower than their siblings. If Ratio is less than or equal
+	// to 1.0, outlier detection is disabled.
+	Ratio float64
+
+	// Samples is the number of samples in each peer's recent average.
+	// Peers are only compared once they have received this many
+	// samples. If zero, DefaultRecentSamples is used.
+	Samples uint
+
+	// MinPeers is the minimum number of peers of a host name, each with
+	// enough samples, needed to detect outliers. If zero,
+	// DefaultOutlierMinPeers is used. Values less than 2 are treated as
+	// 2.
+	MinPeers int
+
+	// MaxEjectedFraction is the maximum fraction of the peers of a host
+	// name which may be ejected at once. The number of peers which may
+	// be ejected is rounded down, so at least one peer is only ejected
+	// if the fraction of the current number of peers is at least one.
+	// If zero, DefaultOutlierMaxEjectedFraction is used.
+	MaxEjectedFraction float64
+}
+
+func (oc OutlierConfig) enabled() bool {
+	return oc.Ratio > 1.0
+}
+
+// An outlierDetector keeps the recent latency of every peer and decides
+// which peers are outliers.
+type outlierDetector struct {
+	config    OutlierConfig
+	lock      sync.Mutex
+	hosts     map[string]map[string]*peerStats
+	lastSweep time.Time
+}
+
+type peerStats struct {
+	recent   avgWindow
+	samples  uint
+	ejected  bool
+	lastSeen time.Time
+}
+
+func newOutlierDetector(config OutlierConfig) *outlierDetector {
+	config.Samples = valOrDef(config.Samples, DefaultRecentSamples)
+	if config.MinPeers == 0 {
+		config.MinPeers = DefaultOutlierMinPeers
+	} else if config.MinPeers < 2 {
+		config.MinPeers = 2
+	}
+	if config.MaxEjectedFraction == 0 {
+		config.MaxEjectedFraction = DefaultOutlierMaxEjectedFraction
+	}
+
+	return &outlierDetector{
+		config: config,
+		hosts:  map[string]map[string]*peerStats{},
+	}
+}
+
+// observe pushes a latency sample for a peer, and reports whether the
+// peer was newly ejected or readmitted as a result.
+func (od *outlierDetector) observe(peer connPeer, sample float64, now time.Time) (ejected, readmitted bool) {
+	od.lock.Lock()
+	defer od.lock.Unlock()
+
+	od.sweep(now)
+	hostname := peer.hostname()
+	peers := od.hosts[hostname]
+	if peers == nil {
+		peers = map[string]*peerStats{}
+		od.hosts[hostname] = peers
+	}
+	ps := peers[peer.ip]
+	if ps == nil {
+		ps = &peerStats{recent: newAvgWindow(od.config.Samples, 0)}
+		peers[peer.ip] = ps
+	}
+	ps.recent.Push(sample)
+	ps.samples++
+	ps.lastSeen = now
+
+	outlier := od.isOutlier(peers, peer.ip)
+	if !ps.ejected && outlier && od.canEject(peers) {
+		ps.ejected = true
+		return true, false
+	} else if ps.ejected && !outlier {
+		ps.ejected = false
+		return false, true
+	}
+	return false, false
+}
+
+// isEjected reports whether a peer is currently ejected.
+func (od *outlierDetector) isEjected(peer connPeer) bool {
+	od.lock.Lock()
+	defer od.lock.Unlock()
+
+	ps := od.hosts[peer.hostname()][peer.ip]
+	return ps != nil && ps.ejected
+}
+
+func (od *outlierDetector) isOutlier(peers map[string]*peerStats, ip string) bool {
+	ps := peers[ip]
+	if ps.samples < od.config.Samples {
+		return false
+	}
+
+	others := make([]float64, 0, len(peers)-1)
+	for otherIP, other := range peers {
+		if otherIP != ip && other.samples >= od.config.Samples {
+			others = append(others, other.recent.Avg())
+		}
+	}
+	if len(others)+1 < od.config.MinPeers {
+		return false
+	}
+
+	// Samples are whole milliseconds, so peers which answer in under a
+	// millisecond average zero, and any peer would be at least Ratio
+	// times slower than them.
+	m := median(others)
+	if m <= 0 {
+		return false
+	}
+	return ps.recent.Avg() >= od.config.Ratio*m
+}
+
+func (od *outlierDetector) canEject(peers map[string]*peerStats) bool {
+	ejected := 0
+	for _, ps := range peers {
+		if ps.ejected {
+			ejected++
+		}
+	}
+	return float64(ejected+1) <= od.config.MaxEjectedFraction*float64(len(peers))
+}
+
+func (od *outlierDetector) sweep(now time.Time) {
+	if now.Sub(od.lastSweep) < recycleSweepInterval {
+		return
+	}
+
+	od.lastSweep = now
+	for hostname, peers := range od.hosts {
+		for ip, ps := range peers {
+			if now.Sub(ps.lastSeen) >= recycleIdleTTL {
+				delete(peers, ip)
+			}
+		}
+		if len(peers) == 0 {
+			delete(od.hosts, hostname)
+		}
+	}
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutlierConfig_enabled(t *testing.T) {
+	assert.False(t, OutlierConfig{}.enabled())
+	assert.False(t, OutlierConfig{Ratio: 1.0}.enabled())
+	assert.True(t, OutlierConfig{Ratio: 1.5}.enabled())
+}
+
+func TestNewOutlierDetector(t *testing.T) {
+	od := newOutlierDetector(OutlierConfig{Ratio: 2.0})
+	assert.Equal(t, OutlierConfig{
+		Ratio:              2.0,
+		Samples:            DefaultRecentSamples,
+		MinPeers:           DefaultOutlierMinPeers,
+		MaxEjectedFraction: DefaultOutlierMaxEjectedFraction,
+	}, od.config)
+
+	od = newOutlierDetector(OutlierConfig{Ratio: 2.0, Samples: 5, MinPeers: 1, MaxEjectedFraction: 0.2})
+	assert.Equal(t, OutlierConfig{
+		Ratio:              2.0,
+		Samples:            5,
+		MinPeers:           2,
+		MaxEjectedFraction: 0.2,
+	}, od.config)
+}
+
+func TestOutlierDetector(t *testing.T) {
+	now := time.Now()
+	a := connPeer{poolHost: "foo:443", ip: "10.0.0.1"}
+	b := connPeer{poolHost: "foo:443", ip: "10.0.0.2"}
+	c := connPeer{poolHost: "foo:80", ip: "10.0.0.3"}
+	d := connPeer{poolHost: "foo", ip: "10.0.0.4"}
+	t.Run("EjectAndReadmit", func(t *testing.T) {
+		od := newOutlierDetector(OutlierConfig{Ratio: 3.0, Samples: 1})
+		od.observe(a, 10.0, now)
+		od.observe(b, 12.0, now)
+
+		ejected, readmitted := od.observe(c, 40.0, now)
+
+		assert.True(t, ejected)
+		assert.False(t, readmitted)
+		assert.True(t, od.isEjected(c))
+		assert.False(t, od.isEjected(connPeer{poolHost: "bar", ip: c.ip}))
+
+		ejected, readmitted = od.observe(c, 20.0, now)
+
+		assert.False(t, ejected)
+		assert.True(t, readmitted)
+		assert.False(t, od.isEjected(c))
+	})
+	t.Run("TooFewPeers", func(t *testing.T) {
+		od := newOutlierDetector(OutlierConfig{Ratio: 3.0, Samples: 1})
+		od.observe(a, 10.0, now)
+
+		ejected, _ := od.observe(b, 100.0, now)
+
+		assert.False(t, ejected)
+	})
+	t.Run("TooFewSamples", func(t *testing.T) {
+		od := newOutlierDetector(OutlierConfig{Ratio: 3.0, Samples: 2})
+		od.observe(a, 10.0, now)
+		od.observe(a, 10.0, now)
+		od.observe(b, 10.0, now)
+		od.observe(b, 10.0, now)
+
+		ejected, _ := od.observe(c, 100.0, now)
+		assert.False(t, ejected)
+		ejected, _ = od.observe(c, 100.0, now)
+		assert.True(t, ejected)
+	})
+	t.Run("MaxEjectedFraction", func(t *testing.T) {
+		od := newOutlierDetector(OutlierConfig{Ratio: 3.0, Samples: 1, MinPeers: 2})
+		od.observe(a, 10.0, now)
+		od.observe(b, 10.0, now)
+		od.observe(c, 10.0, now)
+
+		ejected, _ := od.observe(d, 100.0, now)
+		assert.True(t, ejected)
+		od.observe(a, 10.0, now)
+		ejected, _ = od.observe(c, 1000.0, now)
+		assert.True(t, ejected)
+		od.observe(b, 10.0, now)
+		ejected, _ = od.observe(a, 1000.0, now)
+		assert.False(t, ejected)
+	})
+	t.Run("ZeroMedian", func(t *testing.T) {
+		od := newOutlierDetector(OutlierConfig{Ratio: 3.0, Samples: 1})
+		od.observe(a, 0.0, now)
+		od.observe(b, 0.0, now)
+
+		ejected, _ := od.observe(c, 0.0, now)
+		assert.False(t, ejected)
+		ejected, _ = od.observe(d, 0.0, now)
+		assert.False(t, ejected)
+		assert.False(t, od.isEjected(a))
+	})
+	t.Run("Sweep", func(t *testing.T) {
+		od := newOutlierDetector(OutlierConfig{Ratio: 3.0})
+		od.observe(a, 10.0, now)
+		od.observe(b, 10.0, now.Add(recycleIdleTTL/2))
+		od.observe(connPeer{poolHost: "bar", ip: a.ip}, 10.0, now)
+
+		od.observe(c, 10.0, now.Add(recycleIdleTTL))
+
+		assert.NotContains(t, od.hosts, "bar")
+		assert.Len(t, od.hosts["foo"], 2)
+	})
+}
+
+func TestMedian(t *testing.T) {
+	assert.Equal(t, 3.0, median([]float64{3.0}))
+	assert.Equal(t, 2.0, median([]float64{3.0, 1.0}))
+	assert.Equal(t, 2.0, median([]float64{3.0, 1.0, 2.0}))
+}
+
+func TestOutliers(t *testing.T) {
+	h, l := newHandlerWithLogger(t)
+	var msgs []string
+	l.On("Printf", mock.AnythingOfType("string"), mock.AnythingOfType("[]interface {}")).
+		Run(func(args mock.Arguments) {
+			var msg string
+			renderPrintf(&msg)(args)
+			msgs = append(msgs, msg)
+		})
+	h.conns = newConnTracker(RecycleConfig{})
+	h.outliers = newOutlierDetector(OutlierConfig{Ratio: 3.0, Samples: 1})
+	h.hostLatency["foo"] = &hostEntry{Machine: NewMachine(MachineConfig{})}
+	attempt := func(ip string, latency time.Duration) *attemptState {
+		as := &attemptState{start: time.Now().Add(-latency)}
+		r := traceConnection(h, "foo", 0, &http.Request{URL: &url.URL{Host: "foo"}}, as)
+		trace := httptrace.ContextClientTrace(r.Context())
+		require.NotNil(t, trace)
+		trace.GotConn(httptrace.GotConnInfo{Conn: &fakeConn{local: "10.1.1.1:1234", remote: ip + ":443"}, Reused: true})
+		_, ok := finishAttempt(h, "foo", 0, as, attemptOutcome{end: time.Now(), closed: r.Close})
+		require.True(t, ok)
+		return as
+	}
+
+	attempt("10.0.0.1", 10*time.Millisecond)
+	attempt("10.0.0.2", 10*time.Millisecond)
+	as := attempt("10.0.0.3", 100*time.Millisecond)
+	assert.False(t, as.recycled)
+	as = attempt("10.0.0.3", 100*time.Millisecond)
+	assert.True(t, as.recycled)
+
+	assert.Equal(t, []string{
+		"reconnx: after attempt 0, peer 10.0.0.3 of host foo ejected as outlier",
+		"reconnx: a connection to foo will be recycled after attempt 0 ends (outlier)",
+	}, msgs)
+}
+
+type fakeConn struct {
+	net.Conn
+	local, remote string
+}
+
+func (fc *fakeConn) LocalAddr() net.Addr {
+	return fakeAddr(fc.local)
+}
+
+func (fc *fakeConn) RemoteAddr() net.Addr {
+	return fakeAddr(fc.remote)
+}
+
+type fakeAddr string
+
+func (fakeAddr) Network() string {
+	return "tcp"
+}
+
+func (fa fakeAddr) String() string {
+	return string(fa)
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"sort"
+	"time"
+)
+
+// HostStats describes what the reconnx plugin currently thinks of a
+// single host.
+type HostStats struct {
+	// Host is the host key.
+	Host string
+
+	// MachineStats holds the statistics of the host's Machine. If the
+	// Machine does not report statistics, only the State is set.
+	MachineStats
+
+	// Attempts is the number of request attempts to the host observed
+	// by the plugin.
+	Attempts uint64
+
+	// CloseRequests is the number of request attempts to the host for
+	// which the plugin requested that the connection be closed.
+	CloseRequests uint64
+
+	// Transitions is the number of state transitions made by the
+	// host's Machine.
+	Transitions uint64
+
+	// LastTransition is the time of the most recent state transition
+	// made by the host's Machine. It is the zero time if the Machine
+	// has never changed state.
+	LastTransition time.Time
+}
+
+// A StateChange identifies a transition of a Machine from one state to
+// another.
+type StateChange struct {
+	From State
+	To   State
+}
+
+// Counters holds counters which describe the operation of the reconnx
+// plugin across all hosts since it was installed.
+type Counters struct {
+	// Attempts is the number of request attempts observed.
+	Attempts uint64
+
+	// CloseRequests is the number of request attempts for which the
+	// plugin requested that the connection be closed.
+	CloseRequests uint64
+
+	// Errors is the number of internal errors encountered.
+	Errors uint64
+
+	// Evictions is the number of hosts evicted because of the Config's
+	// MaxHosts or HostIdleTTL.
+	Evictions uint64
+
+	// Transitions counts the state transitions made by all hosts'
+	// Machines, keyed by the transition. Transitions which have never
+	// occurred are absent.
+	Transitions map[StateChange]uint64
+}
+
+// A Plugin is a handle to an installed instance of the reconnx plugin.
+// Use the Install or InstallHandlers functions to install the plugin
+// and obtain a Plugin.
+//
+// A Plugin provides read-only introspection of the plugin's current
+// view of each host, for example to drive admin pages or to make
+// assertions in tests. Plugin is safe for concurrent use by multiple
+// goroutines.
+type Plugin struct {
+	h *handler
+}
+
+// Hosts returns the keys of all hosts currently tracked by the plugin,
+// in sorted order.
+func (p *Plugin) Hosts() []string {
+	p.h.hostLatencyLock.RLock()
+	hosts := make([]string, 0, len(p.h.hostLatency))
+	for host := range p.h.hostLatency {
+		hosts = append(hosts, host)
+	}
+	p.h.hostLatencyLock.RUnlock()
+
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Stats returns the current statistics for a host. The second return
+// value is false if the plugin is not tracking the host.
+func (p *Plugin) Stats(host string) (HostStats, bool) {
+	he := getHostLatencyStateMachine(p.h, host)
+	if he == nil {
+		return HostStats{}, false
+	}
+
+	return he.stats(host), true
+}
+
+// History returns the recent state transitions and close decisions
+// for a host, oldest first. At most Config.HistorySize entries are
+// kept for each host. The second return value is false if the plugin is
+// not tracking the host.
+func (p *Plugin) History(host string) ([]HistoryEntry, bool) {
+	he := getHostLatencyStateMachine(p.h, host)
+	if he == nil {
+		return nil, false
+	}
+
+	return he.recentHistory(), true
+}
+
+// Snapshot returns the current statistics for every host tracked by
+// the plugin, ordered by host key.
+func (p *Plugin) Snapshot() []HostStats {
+	p.h.hostLatencyLock.RLock()
+	entries := make(map[string]*hostEntry, len(p.h.hostLatency))
+	for host, he := range p.h.hostLatency {
+		entries[host] = he
+	}
+	p.h.hostLatencyLock.RUnlock()
+
+	snapshot := make([]HostStats, 0, len(entries))
+	for host, he := range entries {
+		snapshot = append(snapshot, he.stats(host))
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Host < snapshot[j].Host
+	})
+	return snapshot
+}
+
+// Counters returns the current values of the plugin-wide counters.
+func (p *Plugin) Counters() Counters {
+	return p.h.counters.snapshot()
+}
+
+// Close stops the plugin's background work, such as DNS watching. The
+// plugin keeps handling requests after Close is called, but no longer
+// detects DNS changes. Close may be called more than once.
+func (p *Plugin) Close() {
+	if p.h.dns != nil {
+		p.h.dns.close()
+	}
+}
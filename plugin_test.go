@@ -0,0 +1,202 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gogama/httpx"
+	"github.com/gogama/httpx/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlugin(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		p := InstallHandlers(&httpx.HandlerGroup{}, Config{})
+
+		assert.Empty(t, p.Hosts())
+		assert.Empty(t, p.Snapshot())
+		_, ok := p.Stats("foo")
+		assert.False(t, ok)
+		_, ok = p.History("foo")
+		assert.False(t, ok)
+		assert.Equal(t, Counters{Transitions: map[StateChange]uint64{}}, p.Counters())
+	})
+	t.Run("Handler", func(t *testing.T) {
+		p := InstallHandlers(&httpx.HandlerGroup{}, Config{
+			Latency: MachineConfig{
+				HistoricalSamples: 1,
+				RecentSamples:     1,
+				AbsThreshold:      1.0,
+				ClosingStreak:     1,
+				ClosingCount:      1,
+			},
+		})
+		m := newMockMachine(t)
+		m.On("State").Return(Resting)
+		p.h.hostLatency["foo"] = &hostEntry{Machine: m}
+		runAttempt(p.h, "bar")
+		before := time.Now()
+		runAttempt(p.h, "bar")
+		after := time.Now()
+
+		assert.Equal(t, []string{"bar", "foo"}, p.Hosts())
+		stats, ok := p.Stats("bar")
+		require.True(t, ok)
+		assert.False(t, stats.LastTransition.Before(before))
+		assert.False(t, stats.LastTransition.After(after))
+		assert.Equal(t, HostStats{
+			Host: "bar",
+			MachineStats: MachineStats{
+				State:         Watching,
+				Reason:        ReasonClosingStreak,
+				RecentAvg:     stats.RecentAvg,
+				HistoricalAvg: stats.HistoricalAvg,
+			},
+			Attempts:       2,
+			CloseRequests:  1,
+			Transitions:    2,
+			LastTransition: stats.LastTransition,
+		}, stats)
+		snapshot := p.Snapshot()
+		require.Len(t, snapshot, 2)
+		assert.Equal(t, stats, snapshot[0])
+		assert.Equal(t, HostStats{Host: "foo", MachineStats: MachineStats{State: Resting}}, snapshot[1])
+		history, ok := p.History("bar")
+		require.True(t, ok)
+		require.Len(t, history, 3)
+		assert.Equal(t, HistoryEntry{
+			Time:          history[0].Time,
+			Kind:          HistoryTransition,
+			Prev:          Watching,
+			Next:          Closing,
+			Reason:        ReasonAbsThreshold,
+			RecentAvg:     1.0,
+			HistoricalAvg: 1.0,
+		}, history[0])
+		assert.Equal(t, HistoryEntry{
+			Time:          history[1].Time,
+			Kind:          HistoryCloseRequest,
+			Prev:          Closing,
+			Next:          Closing,
+			Reason:        ReasonAbsThreshold,
+			RecentAvg:     1.0,
+			HistoricalAvg: 1.0,
+		}, history[1])
+		assert.Equal(t, HistoryEntry{
+			Time:          stats.LastTransition,
+			Kind:          HistoryTransition,
+			Prev:          Closing,
+			Next:          Watching,
+			Reason:        ReasonClosingStreak,
+			RecentAvg:     1.0,
+			HistoricalAvg: 1.0,
+			ClosedStreak:  1,
+			ClosedCount:   1,
+		}, history[2])
+		assert.False(t, history[1].Time.Before(history[0].Time))
+		history, ok = p.History("foo")
+		require.True(t, ok)
+		assert.Empty(t, history)
+		p.h.Handle(httpx.AfterAttempt, &request.Execution{})
+		counters := p.Counters()
+		assert.Equal(t, Counters{
+			Attempts:      2,
+			CloseRequests: 1,
+			Errors:        1,
+			Transitions: map[StateChange]uint64{
+				{From: Watching, To: Closing}: 1,
+				{From: Closing, To: Watching}: 1,
+			},
+		}, counters)
+		counters.Transitions[StateChange{From: Resting, To: Watching}] = 1
+		assert.Len(t, p.Counters().Transitions, 2)
+	})
+	t.Run("HistorySize", func(t *testing.T) {
+		config := Config{
+			Latency: MachineConfig{
+				RecentSamples: 1,
+				AbsThreshold:  1.0,
+				ClosingStreak: 1,
+				ClosingCount:  1,
+			},
+		}
+		t.Run("Default", func(t *testing.T) {
+			p := InstallHandlers(&httpx.HandlerGroup{}, config)
+			for i := 0; i < DefaultHistorySize; i++ {
+				runAttempt(p.h, "foo")
+			}
+
+			history, ok := p.History("foo")
+
+			require.True(t, ok)
+			assert.Len(t, history, DefaultHistorySize)
+		})
+		t.Run("Positive", func(t *testing.T) {
+			config.HistorySize = 2
+			p := InstallHandlers(&httpx.HandlerGroup{}, config)
+			runAttempt(p.h, "foo")
+			runAttempt(p.h, "foo")
+
+			history, ok := p.History("foo")
+
+			require.True(t, ok)
+			require.Len(t, history, 2)
+			assert.Equal(t, HistoryCloseRequest, history[0].Kind)
+			assert.Equal(t, HistoryTransition, history[1].Kind)
+			assert.Equal(t, Watching, history[1].Next)
+		})
+		t.Run("Negative", func(t *testing.T) {
+			config.HistorySize = -1
+			p := InstallHandlers(&httpx.HandlerGroup{}, config)
+			runAttempt(p.h, "foo")
+
+			history, ok := p.History("foo")
+
+			require.True(t, ok)
+			assert.Empty(t, history)
+		})
+	})
+	t.Run("Client", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		cl := &httpx.Client{}
+		p := Install(cl, Config{})
+
+		_, err = cl.Get(server.URL)
+		require.NoError(t, err)
+		_, err = cl.Get(server.URL)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{u.Host}, p.Hosts())
+		stats, ok := p.Stats(u.Host)
+		require.True(t, ok)
+		assert.Equal(t, uint64(2), stats.Attempts)
+		assert.Equal(t, Watching, stats.State)
+	})
+}
+
+// runAttempt simulates a single-attempt execution to host through the
+// handler, with the attempt taking at least one millisecond.
+func runAttempt(h *handler, host string) *request.Execution {
+	e := &request.Execution{
+		Plan:    &request.Plan{Host: host},
+		Request: &http.Request{},
+	}
+	h.Handle(httpx.BeforeExecutionStart, e)
+	h.Handle(httpx.BeforeAttempt, e)
+	time.Sleep(time.Millisecond)
+	h.Handle(httpx.AfterAttempt, e)
+	return e
+}
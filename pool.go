@@ -0,0 +1,255 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const nilRequestURLMsg = "reconnx: nil request URL"
+
+// A Pool is an http.RoundTripper which keeps a separate connection pool
+// for each host, so that the idle connections to one host can be closed
+// without disturbing the connections to any other host.
+//
+// The Go standard library http.Transport can only close all of its idle
+// connections at once. Setting the Close field of a request, which is
+// what the reconnx plugin does while a host is in the Closing state,
+// only recycles the connections which happen to be picked for new
+// request attempts, while the other idle connections to the host stay
+// in the pool. When a Pool is set in the plugin's Config, the plugin
+// closes all idle connections to a host the moment the host's Machine
+// enters the Closing state, so the bad connections are recycled much
+// faster.
+//
+// Over HTTP/2, every request to a host is multiplexed onto the same
+// connection. Setting the Close field of one request makes net/http stop
+// reusing the connection once that request has been sent, and the
+// plugin additionally retires the host's connection pool in the Pool,
+// moving new requests to the host onto a new connection straight away.
+// See the Retire method.
+//
+// To use a Pool, make it the transport of the client's HTTPDoer and set
+// it in the plugin's Config:
+//
+//	pool := reconnx.NewPool(nil)
+//	cl := &httpx.Client{HTTPDoer: &http.Client{Transport: pool}}
+//	reconnx.OnClient(cl, reconnx.Config{Pool: pool, Latency: ...})
+//
+// Pool is safe for concurrent use by multiple goroutines.
+type Pool struct {
+	base       *http.Transport
+	lock       sync.Mutex
+	transports map[string]*http.Transport
+	inFlight   map[*http.Transport]int
+	drains     map[*http.Transport]func()
+}
+
+// NewPool constructs a Pool whose per-host connection pools are clones
+// of base. If base is nil, a clone of http.DefaultTransport is used.
+//
+// Because every host gets its own clone of base, limits such as
+// MaxIdleConns apply separately to each host.
+func NewPool(base *http.Transport) *Pool {
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+
+	return &Pool{
+		base:       base.Clone(),
+		transports: map[string]*http.Transport{},
+		inFlight:   map[*http.Transport]int{},
+		drains:     map[*http.Transport]func(){},
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface by sending the
+// request on the connection pool for the host in its URL.
+func (p *Pool) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL == nil {
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
+		return nil, errors.New(nilRequestURLMsg)
+	}
+
+	t := p.acquire(r.URL.Host)
+	resp, err := t.RoundTrip(r)
+	if err != nil {
+		p.release(t)
+		return nil, err
+	}
+
+	if resp.Body == nil || resp.Body == http.NoBody {
+		p.release(t)
+		return resp, nil
+	}
+
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { p.release(t) }}
+	return resp, nil
+}
+
+// CloseIdle closes the idle connections to a host. The host is matched
+// against the Host field of each request's URL. Connections which are
+// currently in use are not affected.
+func (p *Pool) CloseIdle(host string) {
+	p.lock.Lock()
+	t := p.transports[host]
+	p.lock.Unlock()
+
+	if t != nil {
+		t.CloseIdleConnections()
+	}
+}
+
+// Retire replaces the connection pool for a host with a new, empty one,
+// so that requests to the host sent after Retire returns are sent on new
+// connections. The host is matched against the Host field of each
+// request's URL.
+//
+// The idle connections in the retired pool are closed immediately.
+// Connections which are currently in use are left to finish their
+// requests. An HTTP/1 connection is then closed as soon as it becomes
+// idle. An HTTP/2 connection is closed as soon as it becomes idle if a
+// request with the Close field set was sent on it, as the plugin does
+// while a host is in the Closing state, and otherwise once it has been
+// idle for the IdleConnTimeout of the base transport.
+func (p *Pool) Retire(host string) {
+	p.lock.Lock()
+	t := p.transports[host]
+	if t != nil {
+		p.transports[host] = p.base.Clone()
+	}
+	p.lock.Unlock()
+
+	if t != nil {
+		t.CloseIdleConnections()
+	}
+}
+
+// Drain retires the connection pool for a host in the same way as
+// Retire, but instead of closing the retired connections straight away,
+// waits until every request in flight on them has finished, including
+// reading or closing its response body. It then closes the retired
+// connections and calls done, which may happen before Drain returns.
+//
+// Drain returns false, and never calls done, if the Pool has no
+// connection pool for the host.
+func (p *Pool) Drain(host string, done func()) bool {
+	p.lock.Lock()
+	t := p.transports[host]
+	if t == nil {
+		p.lock.Unlock()
+		return false
+	}
+	p.transports[host] = p.base.Clone()
+	idle := p.inFlight[t] == 0
+	if !idle {
+		p.drains[t] = done
+	}
+	p.lock.Unlock()
+
+	if idle {
+		t.CloseIdleConnections()
+		done()
+	}
+	return true
+}
+
+// Forget discards the connection pool for a host, closing its idle
+// connections, so that a Pool used with many short-lived hosts does not
+// grow without bound. Requests in flight on the discarded pool are
+// allowed to finish, and a later request to the host gets a new pool.
+func (p *Pool) Forget(host string) {
+	p.lock.Lock()
+	t := p.transports[host]
+	delete(p.transports, host)
+	p.lock.Unlock()
+
+	if t != nil {
+		t.CloseIdleConnections()
+	}
+}
+
+// CloseIdleConnections closes the idle connections to every host. The
+// http.Client calls this method from its own CloseIdleConnections
+// method.
+func (p *Pool) CloseIdleConnections() {
+	p.lock.Lock()
+	transports := make([]*http.Transport, 0, len(p.transports))
+	for _, t := range p.transports {
+		transports = append(transports, t)
+	}
+	p.lock.Unlock()
+
+	for _, t := range transports {
+		t.CloseIdleConnections()
+	}
+}
+
+func (p *Pool) transportLocked(host string) *http.Transport {
+	t := p.transports[host]
+	if t == nil {
+		t = p.base.Clone()
+		p.transports[host] = t
+	}
+	return t
+}
+
+// acquire returns the connection pool for a host, counting a request in
+// flight on it.
+func (p *Pool) acquire(host string) *http.Transport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	t := p.transportLocked(host)
+	p.inFlight[t]++
+	return t
+}
+
+// release counts a request in flight on the connection pool t as
+// finished, and completes the pool's drain if it was the last one.
+func (p *Pool) release(t *http.Transport) {
+	p.lock.Lock()
+	p.inFlight[t]--
+	if p.inFlight[t] > 0 {
+		p.lock.Unlock()
+		return
+	}
+	delete(p.inFlight, t)
+	done := p.drains[t]
+	delete(p.drains, t)
+	p.lock.Unlock()
+
+	if done != nil {
+		t.CloseIdleConnections()
+		done()
+	}
+}
+
+// A releaseBody is a response body which releases its request's
+// connection pool when it is fully read, when a read fails, or when it
+// is closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (rb *releaseBody) Read(p []byte) (int, error) {
+	n, err := rb.ReadCloser.Read(p)
+	if err != nil {
+		rb.once.Do(rb.release)
+	}
+	return n, err
+}
+
+func (rb *releaseBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.once.Do(rb.release)
+	return err
+}
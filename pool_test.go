@@ -0,0 +1,216 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPool(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		p := NewPool(nil)
+
+		require.NotNil(t, p.base)
+		assert.NotSame(t, http.DefaultTransport, p.base)
+		assert.Empty(t, p.transports)
+	})
+	t.Run("Base", func(t *testing.T) {
+		base := &http.Transport{MaxIdleConnsPerHost: 7}
+
+		p := NewPool(base)
+
+		require.NotNil(t, p.base)
+		assert.NotSame(t, base, p.base)
+		assert.Equal(t, 7, p.base.MaxIdleConnsPerHost)
+	})
+}
+
+func TestPool(t *testing.T) {
+	t.Run("NilURL", func(t *testing.T) {
+		p := NewPool(nil)
+		body := &closeRecorder{Reader: strings.NewReader("foo")}
+
+		_, err := p.RoundTrip(&http.Request{Body: body})
+
+		assert.EqualError(t, err, nilRequestURLMsg)
+		assert.True(t, body.closed)
+	})
+	t.Run("PerHost", func(t *testing.T) {
+		s1, closed1 := newConnTrackingServer(t)
+		defer s1.Close()
+		s2, closed2 := newConnTrackingServer(t)
+		defer s2.Close()
+		p := NewPool(nil)
+		cl := &http.Client{Transport: p}
+
+		get(t, cl, s1.URL)
+		get(t, cl, s2.URL)
+
+		assert.Len(t, p.transports, 2)
+		p.CloseIdle(hostOf(t, s1.URL))
+		p.CloseIdle("unknown")
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(closed1) == 1 }, time.Second, time.Millisecond)
+		assert.Equal(t, int32(0), atomic.LoadInt32(closed2))
+		cl.CloseIdleConnections()
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(closed2) == 1 }, time.Second, time.Millisecond)
+	})
+	t.Run("Retire", func(t *testing.T) {
+		server, closed := newConnTrackingServer(t)
+		defer server.Close()
+		p := NewPool(nil)
+		cl := &http.Client{Transport: p}
+		get(t, cl, server.URL)
+		before := p.transports[hostOf(t, server.URL)]
+
+		p.Retire(hostOf(t, server.URL))
+		p.Retire("unknown")
+
+		assert.Len(t, p.transports, 1)
+		assert.NotSame(t, before, p.transports[hostOf(t, server.URL)])
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(closed) == 1 }, time.Second, time.Millisecond)
+	})
+	t.Run("Forget", func(t *testing.T) {
+		server, closed := newConnTrackingServer(t)
+		defer server.Close()
+		p := NewPool(nil)
+		cl := &http.Client{Transport: p}
+		get(t, cl, server.URL)
+
+		p.Forget(hostOf(t, server.URL))
+		p.Forget("unknown")
+
+		assert.Empty(t, p.transports)
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(closed) == 1 }, time.Second, time.Millisecond)
+		get(t, cl, server.URL)
+		assert.Len(t, p.transports, 1)
+	})
+	t.Run("Drain", func(t *testing.T) {
+		t.Run("Unknown", func(t *testing.T) {
+			p := NewPool(nil)
+
+			ok := p.Drain("unknown", func() { t.Fatal("unexpected call to done") })
+
+			assert.False(t, ok)
+		})
+		t.Run("Idle", func(t *testing.T) {
+			server, closed := newConnTrackingServer(t)
+			defer server.Close()
+			p := NewPool(nil)
+			get(t, &http.Client{Transport: p}, server.URL)
+			var done bool
+
+			ok := p.Drain(hostOf(t, server.URL), func() { done = true })
+
+			assert.True(t, ok)
+			assert.True(t, done)
+			assert.Empty(t, p.inFlight)
+			assert.Eventually(t, func() bool { return atomic.LoadInt32(closed) == 1 }, time.Second, time.Millisecond)
+		})
+		t.Run("InFlight", func(t *testing.T) {
+			server, closed := newConnTrackingServer(t)
+			defer server.Close()
+			p := NewPool(nil)
+			cl := &http.Client{Transport: p}
+			resp, err := cl.Get(server.URL + "/body")
+			require.NoError(t, err)
+			var done int32
+
+			ok := p.Drain(hostOf(t, server.URL), func() { atomic.AddInt32(&done, 1) })
+
+			assert.True(t, ok)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&done))
+			get(t, cl, server.URL)
+			assert.Equal(t, int32(0), atomic.LoadInt32(&done))
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			require.NoError(t, resp.Body.Close())
+			assert.Equal(t, int32(1), atomic.LoadInt32(&done))
+			assert.Eventually(t, func() bool { return atomic.LoadInt32(closed) == 1 }, time.Second, time.Millisecond)
+			assert.Empty(t, p.drains)
+		})
+	})
+	t.Run("RetireHTTP2", func(t *testing.T) {
+		var opened int32
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		server.EnableHTTP2 = true
+		server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&opened, 1)
+			}
+		}
+		server.StartTLS()
+		defer server.Close()
+		p := NewPool(server.Client().Transport.(*http.Transport))
+		cl := &http.Client{Transport: p}
+
+		resp, err := cl.Get(server.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, 2, resp.ProtoMajor)
+		get(t, cl, server.URL)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&opened))
+		p.Retire(hostOf(t, server.URL))
+		get(t, cl, server.URL)
+
+		assert.Equal(t, int32(2), atomic.LoadInt32(&opened))
+	})
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+// newConnTrackingServer starts a test server which counts the number of
+// connections closed. It responds to the path /body with a short body,
+// and to any other path with no content.
+func newConnTrackingServer(t *testing.T) (*httptest.Server, *int32) {
+	var closed int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/body" {
+			_, _ = w.Write([]byte("foo"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	s.Start()
+	return s, &closed
+}
+
+func get(t *testing.T, cl *http.Client, u string) {
+	resp, err := cl.Get(u)
+	require.NoError(t, err)
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	require.NoError(t, resp.Body.Close())
+}
+
+func hostOf(t *testing.T, u string) string {
+	parsed, err := url.Parse(u)
+	require.NoError(t, err)
+	return parsed.Host
+}
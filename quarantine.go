@@ -0,0 +1,348 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQuarantinePeriod is the default time for which the addresses of
+// peers whose connections were closed for being slow are avoided.
+const DefaultQuarantinePeriod = 30 * time.Second
+
+// DefaultQuarantineTimeout is the default maximum time a Quarantine
+// spends dialing a host name, the same as the dial timeout of
+// http.DefaultTransport.
+const DefaultQuarantineTimeout = 30 * time.Second
+
+// DefaultFallbackDelay is the default time a Quarantine waits for a
+// connection to an address of the preferred family before it starts
+// dialing the addresses of the other family, the same as the default of
+// net.Dialer.
+const DefaultFallbackDelay = 300 * time.Millisecond
+
+const noAddressesMsg = "reconnx: no addresses"
+
+// A QuarantineConfig specifies how a Quarantine dials connections.
+type QuarantineConfig struct {
+	// Period is the time for which an address stays quarantined after
+	// the plugin closes a connection to it. If Period is zero or
+	// negative, DefaultQuarantinePeriod is used.
+	Period time.Duration
+
+	// Resolver is used to look up host names. If nil, the standard
+	// library net.DefaultResolver is used.
+	Resolver Resolver
+
+	// Dial dials a connection to a literal IP address and port. If nil,
+	// a net.Dialer with the same timeout and keep-alive settings as
+	// http.DefaultTransport is used.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Timeout is the maximum time for dialing a host name. As with
+	// net.Dialer, the time is split between the host name's addresses,
+	// so that one unreachable address does not use up all of it. If
+	// the context passed to DialContext has an earlier deadline, that
+	// deadline is used instead. If Timeout is zero or negative,
+	// DefaultQuarantineTimeout is used.
+	Timeout time.Duration
+
+	// FallbackDelay is the time to wait for a connection to an address
+	// of the family of the first address in the DNS answer, IPv4 or
+	// IPv6, before also dialing the addresses of the other family, as
+	// net.Dialer does ("Happy Eyeballs", RFC 6555). If FallbackDelay is
+	// zero, DefaultFallbackDelay is used. If it is negative, the
+	// addresses are dialed one at a time regardless of family.
+	FallbackDelay time.Duration
+}
+
+// A Quarantine dials connections in a way which avoids the addresses of
+// peers whose connections the reconnx plugin recently closed for being
+// slow, so that closing a connection actually moves traffic onto a
+// different server.
+//
+// Without a Quarantine, the replacement for a closed connection is
+// often dialed straight back to the same IP address. Its DialContext
+// method instead resolves the host name itself and tries the addresses
+// in the DNS answer which are not quarantined first, falling back to
+// the quarantined addresses only when no other address can be dialed.
+//
+// An address is quarantined only for the host name whose connection
+// was closed, so that when several virtual hosts share an IP address,
+// as behind a CDN or an ingress, one slow host does not cause the
+// others to avoid the address.
+//
+// As with net.Dialer, the addresses of each family are dialed one at a
+// time, each with a share of the dial timeout, and if the DNS answer
+// has both IPv4 and IPv6 addresses, the addresses of the second family
+// are dialed in parallel once the FallbackDelay has passed. Within each
+// family, the addresses which are not quarantined are dialed first.
+//
+// To use a Quarantine, make its DialContext method the DialContext of
+// the client's transport and set it in the plugin's Config:
+//
+//	q := reconnx.NewQuarantine(reconnx.QuarantineConfig{})
+//	t := http.DefaultTransport.(*http.Transport).Clone()
+//	t.DialContext = q.DialContext
+//	cl := &httpx.Client{HTTPDoer: &http.Client{Transport: t}}
+//	reconnx.OnClient(cl, reconnx.Config{Quarantine: q, Latency: ...})
+//
+// Quarantine is safe for concurrent use by multiple goroutines.
+type Quarantine struct {
+	config QuarantineConfig
+	lock   sync.Mutex
+	until  map[quarantineKey]time.Time
+}
+
+// A quarantineKey identifies a quarantined address of a host name.
+type quarantineKey struct {
+	hostname string
+	ip       string
+}
+
+func newQuarantineKey(hostname, ip string) quarantineKey {
+	return quarantineKey{hostname: strings.ToLower(hostname), ip: ip}
+}
+
+// NewQuarantine constructs a Quarantine with the given configuration,
+// filling in defaults for the optional fields.
+func NewQuarantine(config QuarantineConfig) *Quarantine {
+	if config.Period <= 0 {
+		config.Period = DefaultQuarantinePeriod
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultQuarantineTimeout
+	}
+	if config.FallbackDelay == 0 {
+		config.FallbackDelay = DefaultFallbackDelay
+	}
+	if config.Resolver == nil {
+		config.Resolver = net.DefaultResolver
+	}
+	if config.Dial == nil {
+		config.Dial = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+
+	return &Quarantine{
+		config: config,
+		until:  map[quarantineKey]time.Time{},
+	}
+}
+
+// DialContext dials a connection to address, which is a host name or
+// literal IP address together with a port, preferring the addresses
+// which are not quarantined. Its signature matches the DialContext
+// field of http.Transport.
+func (q *Quarantine) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return q.config.Dial(ctx, network, address)
+	}
+
+	now := time.Now()
+	deadline := now.Add(q.config.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	addrs, err := q.config.Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	primaries, fallbacks := partition(network, q.order(host, addrs, now))
+	if len(primaries) == 0 {
+		return nil, errors.New(noAddressesMsg)
+	}
+	if len(fallbacks) == 0 || q.config.FallbackDelay < 0 {
+		return q.dialSerial(ctx, network, port, append(primaries, fallbacks...))
+	}
+	return q.dialParallel(ctx, network, port, primaries, fallbacks)
+}
+
+// A dialResult is the result of dialing the addresses of one family.
+type dialResult struct {
+	conn    net.Conn
+	err     error
+	primary bool
+	done    bool
+}
+
+// dialParallel dials the primary addresses, and starts dialing the
+// fallback addresses in parallel if the primary addresses have not
+// produced a connection within the FallbackDelay. It returns the first
+// connection established, or the error from the primary addresses if
+// every address fails.
+func (q *Quarantine) dialParallel(ctx context.Context, network, port string, primaries, fallbacks []string) (net.Conn, error) {
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+
+	race := func(ctx context.Context, primary bool) {
+		addrs := primaries
+		if !primary {
+			addrs = fallbacks
+		}
+		conn, err := q.dialSerial(ctx, network, port, addrs)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary, done: true}:
+		case <-returned:
+			if conn != nil {
+				_ = conn.Close()
+			}
+		}
+	}
+
+	primaryCtx, primaryCancel := context.WithCancel(ctx)
+	defer primaryCancel()
+	go race(primaryCtx, true)
+
+	fallbackTimer := time.NewTimer(q.config.FallbackDelay)
+	defer fallbackTimer.Stop()
+	fallbackCtx, fallbackCancel := context.WithCancel(ctx)
+	defer fallbackCancel()
+
+	var primary, fallback dialResult
+	for {
+		select {
+		case <-fallbackTimer.C:
+			go race(fallbackCtx, false)
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primary = res
+			} else {
+				fallback = res
+			}
+			if primary.done && fallback.done {
+				return nil, primary.err
+			}
+			if res.primary && fallbackTimer.Stop() {
+				// The primary addresses failed before the fallback
+				// delay passed, so start on the fallbacks right away.
+				fallbackTimer.Reset(0)
+			}
+		}
+	}
+}
+
+// dialSerial dials addrs one at a time, giving each a share of the time
+// left before the deadline of ctx, and returns the first connection
+// established, or the last error if every address fails.
+func (q *Quarantine) dialSerial(ctx context.Context, network, port string, addrs []string) (net.Conn, error) {
+	var err error
+	for i, addr := range addrs {
+		dialCtx := ctx
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			dialCtx, cancel = context.WithDeadline(ctx, partialDeadline(time.Now(), deadline, len(addrs)-i))
+			defer cancel()
+		}
+		var conn net.Conn
+		conn, err = q.config.Dial(dialCtx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// partialDeadline returns the deadline for dialing one of the remaining
+// addresses, splitting the time left before deadline between them in
+// the same way as net.Dialer.
+func partialDeadline(now, deadline time.Time, remaining int) time.Time {
+	const saneMinimum = 2 * time.Second
+	left := deadline.Sub(now)
+	if left <= 0 {
+		return deadline
+	}
+	timeout := left / time.Duration(remaining)
+	if timeout < saneMinimum {
+		if left < saneMinimum {
+			timeout = left
+		} else {
+			timeout = saneMinimum
+		}
+	}
+	return now.Add(timeout)
+}
+
+// partition splits addrs into the addresses of the same family as the
+// first one, and the addresses of the other family, in the same way as
+// net.Dialer. If network only allows one family, the addresses of the
+// other family are dropped.
+func partition(network string, addrs []string) (primaries, fallbacks []string) {
+	for _, addr := range addrs {
+		ip4 := net.ParseIP(addr).To4() != nil
+		if (strings.HasSuffix(network, "4") && !ip4) || (strings.HasSuffix(network, "6") && ip4) {
+			continue
+		}
+		if len(primaries) == 0 || ip4 == (net.ParseIP(primaries[0]).To4() != nil) {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return
+}
+
+// Quarantined reports whether the address ip is currently quarantined
+// for the host name hostname.
+func (q *Quarantine) Quarantined(hostname, ip string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.quarantined(newQuarantineKey(hostname, ip), time.Now())
+}
+
+// add quarantines the address ip for the host name hostname until the
+// quarantine period has passed from now.
+func (q *Quarantine) add(hostname, ip string, now time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.until[newQuarantineKey(hostname, ip)] = now.Add(q.config.Period)
+	for key, until := range q.until {
+		if !now.Before(until) {
+			delete(q.until, key)
+		}
+	}
+}
+
+// order returns the addresses addrs of the host name hostname with the
+// addresses which are not quarantined first, and the quarantined
+// addresses last, otherwise preserving the order of the DNS answer.
+func (q *Quarantine) order(hostname string, addrs []string, now time.Time) []string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	ordered := make([]string, 0, len(addrs))
+	var quarantined []string
+	for _, addr := range addrs {
+		if q.quarantined(newQuarantineKey(hostname, addr), now) {
+			quarantined = append(quarantined, addr)
+		} else {
+			ordered = append(ordered, addr)
+		}
+	}
+	return append(ordered, quarantined...)
+}
+
+func (q *Quarantine) quarantined(key quarantineKey, now time.Time) bool {
+	until, ok := q.until[key]
+	return ok && now.Before(until)
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogama/httpx"
+	"github.com/gogama/httpx/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewQuarantine(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		q := NewQuarantine(QuarantineConfig{})
+
+		assert.Equal(t, DefaultQuarantinePeriod, q.config.Period)
+		assert.Equal(t, DefaultQuarantineTimeout, q.config.Timeout)
+		assert.Equal(t, DefaultFallbackDelay, q.config.FallbackDelay)
+		assert.Same(t, net.DefaultResolver, q.config.Resolver)
+		assert.NotNil(t, q.config.Dial)
+		assert.Empty(t, q.until)
+	})
+	t.Run("Custom", func(t *testing.T) {
+		r := newMockResolver(t)
+
+		q := NewQuarantine(QuarantineConfig{Period: time.Minute, Resolver: r, Timeout: time.Second, FallbackDelay: -1})
+
+		assert.Equal(t, time.Minute, q.config.Period)
+		assert.Equal(t, time.Second, q.config.Timeout)
+		assert.Equal(t, time.Duration(-1), q.config.FallbackDelay)
+		assert.Same(t, r, q.config.Resolver)
+	})
+}
+
+func TestQuarantine(t *testing.T) {
+	t.Run("Quarantined", func(t *testing.T) {
+		q := NewQuarantine(QuarantineConfig{Period: time.Minute})
+		now := time.Now()
+
+		q.add("foo", "10.0.0.1", now.Add(-2*time.Minute))
+		q.add("foo", "10.0.0.2", now)
+
+		assert.False(t, q.Quarantined("foo", "10.0.0.1"))
+		assert.True(t, q.Quarantined("foo", "10.0.0.2"))
+		assert.True(t, q.Quarantined("FOO", "10.0.0.2"))
+		assert.False(t, q.Quarantined("bar", "10.0.0.2"))
+		assert.Len(t, q.until, 1)
+	})
+	t.Run("DialContext", func(t *testing.T) {
+		errDial := errors.New("dial failed")
+		newQuarantine := func(t *testing.T, fail map[string]bool) (*Quarantine, *mockResolver, *[]string) {
+			r := newMockResolver(t)
+			var lock sync.Mutex
+			var dialed []string
+			q := NewQuarantine(QuarantineConfig{
+				Resolver: r,
+				Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					lock.Lock()
+					dialed = append(dialed, address)
+					lock.Unlock()
+					if fail[address] {
+						return nil, errDial
+					}
+					if address == "[::2]:80" {
+						<-ctx.Done()
+						return nil, ctx.Err()
+					}
+					return &fakeConn{remote: address}, nil
+				},
+			})
+			return q, r, &dialed
+		}
+		t.Run("Literal", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, nil)
+
+			_, err := q.DialContext(context.Background(), "tcp", "10.0.0.1:80")
+			require.NoError(t, err)
+			_, err = q.DialContext(context.Background(), "tcp", "[::1]:80")
+			require.NoError(t, err)
+
+			r.AssertExpectations(t)
+			assert.Equal(t, []string{"10.0.0.1:80", "[::1]:80"}, *dialed)
+		})
+		t.Run("LookupError", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, nil)
+			r.On("LookupHost", "foo").Return([]string(nil), errors.New("no such host")).Once()
+
+			_, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			assert.EqualError(t, err, "no such host")
+			r.AssertExpectations(t)
+			assert.Empty(t, *dialed)
+		})
+		t.Run("NoAddresses", func(t *testing.T) {
+			q, r, _ := newQuarantine(t, nil)
+			r.On("LookupHost", "foo").Return([]string{}, nil).Once()
+
+			_, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			assert.EqualError(t, err, noAddressesMsg)
+		})
+		t.Run("PreferNotQuarantined", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, nil)
+			r.On("LookupHost", "foo").Return([]string{"10.0.0.1", "10.0.0.2"}, nil).Once()
+			q.add("foo", "10.0.0.1", time.Now())
+
+			conn, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.2:80", conn.RemoteAddr().String())
+			assert.Equal(t, []string{"10.0.0.2:80"}, *dialed)
+		})
+		t.Run("FallBackToQuarantined", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, map[string]bool{"10.0.0.2:80": true})
+			r.On("LookupHost", "foo").Return([]string{"10.0.0.1", "10.0.0.2"}, nil).Once()
+			q.add("foo", "10.0.0.1", time.Now())
+
+			conn, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.1:80", conn.RemoteAddr().String())
+			assert.Equal(t, []string{"10.0.0.2:80", "10.0.0.1:80"}, *dialed)
+		})
+		t.Run("OtherHostname", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, nil)
+			r.On("LookupHost", "foo").Return([]string{"10.0.0.1", "10.0.0.2"}, nil).Once()
+			q.add("bar", "10.0.0.1", time.Now())
+
+			conn, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.1:80", conn.RemoteAddr().String())
+			assert.Equal(t, []string{"10.0.0.1:80"}, *dialed)
+		})
+		t.Run("AllFail", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, map[string]bool{"10.0.0.1:80": true, "10.0.0.2:80": true})
+			r.On("LookupHost", "foo").Return([]string{"10.0.0.1", "10.0.0.2"}, nil).Once()
+
+			_, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			assert.Same(t, errDial, err)
+			assert.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, *dialed)
+		})
+		t.Run("Deadline", func(t *testing.T) {
+			q, r, _ := newQuarantine(t, nil)
+			r.On("LookupHost", "foo").Return([]string{"10.0.0.1", "10.0.0.2"}, nil).Once()
+			q.config.Timeout = 10 * time.Second
+			var deadline time.Time
+			q.config.Dial = func(ctx context.Context, _, address string) (net.Conn, error) {
+				deadline, _ = ctx.Deadline()
+				return &fakeConn{remote: address}, nil
+			}
+			start := time.Now()
+
+			_, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			require.NoError(t, err)
+			assert.WithinDuration(t, start.Add(5*time.Second), deadline, time.Second)
+		})
+		t.Run("FallbackDelay", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, nil)
+			r.On("LookupHost", "foo").Return([]string{"::2", "10.0.0.2"}, nil).Once()
+			q.config.FallbackDelay = 10 * time.Millisecond
+
+			conn, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.2:80", conn.RemoteAddr().String())
+			assert.Equal(t, []string{"[::2]:80", "10.0.0.2:80"}, *dialed)
+		})
+		t.Run("PrimaryFails", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, map[string]bool{"[::1]:80": true})
+			r.On("LookupHost", "foo").Return([]string{"::1", "10.0.0.2"}, nil).Once()
+			q.config.FallbackDelay = time.Hour
+
+			conn, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.2:80", conn.RemoteAddr().String())
+			assert.Equal(t, []string{"[::1]:80", "10.0.0.2:80"}, *dialed)
+		})
+		t.Run("BothFamiliesFail", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, map[string]bool{"[::1]:80": true, "10.0.0.1:80": true})
+			r.On("LookupHost", "foo").Return([]string{"::1", "10.0.0.1"}, nil).Once()
+
+			_, err := q.DialContext(context.Background(), "tcp", "foo:80")
+
+			assert.Same(t, errDial, err)
+			assert.ElementsMatch(t, []string{"[::1]:80", "10.0.0.1:80"}, *dialed)
+		})
+		t.Run("Network", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, nil)
+			r.On("LookupHost", "foo").Return([]string{"::1", "10.0.0.1"}, nil).Once()
+
+			conn, err := q.DialContext(context.Background(), "tcp4", "foo:80")
+
+			require.NoError(t, err)
+			assert.Equal(t, "10.0.0.1:80", conn.RemoteAddr().String())
+			assert.Equal(t, []string{"10.0.0.1:80"}, *dialed)
+		})
+		t.Run("Canceled", func(t *testing.T) {
+			q, r, dialed := newQuarantine(t, map[string]bool{"10.0.0.1:80": true})
+			r.On("LookupHost", "foo").Return([]string{"10.0.0.1", "10.0.0.2"}, nil).Once()
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := q.DialContext(ctx, "tcp", "foo:80")
+
+			assert.Same(t, errDial, err)
+			assert.Equal(t, []string{"10.0.0.1:80"}, *dialed)
+		})
+	})
+	t.Run("PartialDeadline", func(t *testing.T) {
+		now := time.Now()
+
+		assert.Equal(t, now.Add(10*time.Second), partialDeadline(now, now.Add(30*time.Second), 3))
+		assert.Equal(t, now.Add(2*time.Second), partialDeadline(now, now.Add(3*time.Second), 3))
+		assert.Equal(t, now.Add(time.Second), partialDeadline(now, now.Add(time.Second), 3))
+		assert.Equal(t, now.Add(-time.Second), partialDeadline(now, now.Add(-time.Second), 3))
+	})
+	t.Run("Handler", func(t *testing.T) {
+		h, l := newHandlerWithLogger(t)
+		var debugMsg string
+		l.On("Printf", "reconnx: after attempt %d, peer %s of host %s quarantined", mock.AnythingOfType("[]interface {}")).
+			Run(renderPrintf(&debugMsg)).
+			Once()
+		h.Quarantine = NewQuarantine(QuarantineConfig{})
+		h.conns = newConnTracker(RecycleConfig{})
+		m := newMockMachine(t)
+		m.On("Next", mock.AnythingOfType("float64"), true).Return(Closing, Closing).Once()
+		h.hostLatency["foo"] = &hostEntry{Machine: m}
+		e := &request.Execution{
+			Plan:    &request.Plan{Host: "foo"},
+			Request: &http.Request{Close: true},
+		}
+		e.SetValue(executionStateKey, &executionState{
+			attempts: []*attemptState{{
+				start: time.Now(),
+				state: Closing,
+				close: true,
+				conn:  "10.1.1.1:1234->10.0.0.1:80",
+				peer:  connPeer{poolHost: "foo:80", ip: "10.0.0.1"},
+			}},
+		})
+
+		h.Handle(httpx.AfterAttempt, e)
+
+		l.AssertExpectations(t)
+		m.AssertExpectations(t)
+		assert.Equal(t, "reconnx: after attempt 0, peer 10.0.0.1 of host foo quarantined", debugMsg)
+		assert.True(t, h.Quarantine.Quarantined("foo", "10.0.0.1"))
+		assert.False(t, h.Quarantine.Quarantined("bar", "10.0.0.1"))
+	})
+}
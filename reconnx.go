@@ -4,7 +4,12 @@
 
 package reconnx
 
-import "github.com/gogama/httpx"
+import (
+	"errors"
+	"time"
+
+	"github.com/gogama/httpx"
+)
 
 const (
 	nilClientMsg       = "reconnx: nil client"
@@ -17,16 +22,190 @@ type Config struct {
 	// interesting events. If nil, the NopLogger is used.
 	Logger Logger
 
+	// StructuredLogger is an optional leveled logger with key/value
+	// fields. If it is not nil, the plugin reports errors and
+	// interesting events to StructuredLogger instead of Logger.
+	StructuredLogger StructuredLogger
+
+	// Listener receives typed notifications about host state changes,
+	// close decisions, and internal errors. If nil, the NopListener is
+	// used.
+	Listener Listener
+
+	// Metrics receives counters, gauges and histograms describing every
+	// request attempt, close decision, and state transition. If nil,
+	// the NopMetrics is used.
+	Metrics Metrics
+
 	// Latency specifies when to close connections to a host due to
 	// latency experienced in sending requests to that host.
 	//
 	// The unit Latency is milliseconds, so the AbsThreshold field must
 	// be specified in milliseconds.
 	//
+	// The latency of a request attempt is measured from the start of the
+	// attempt until its response body has been read. With the httpx
+	// plugin, this is when httpx fires the AfterAttempt event, after
+	// reading the whole body. With a Transport, it is when the caller
+	// reads the body to the end or closes it, unless HeaderLatency is
+	// set.
+	//
 	// Note that the zero value will result in connections never being
 	// closed. At a minimum, the AbsThreshold, ClosingStreak, and
 	// ClosingCount members should be set to positive values.
 	Latency MachineConfig
+
+	// HostLatency optionally overrides Latency for some hosts. It maps
+	// host patterns to the MachineConfig used for the hosts they match.
+	// A host pattern is one of:
+	//
+	//	- an exact host name, such as "api.example.com";
+	//	- a CIDR block, such as "10.0.0.0/8", which matches hosts which
+	//	  are literal IP addresses within the block;
+	//	- a suffix starting with a dot, such as ".example.com", which
+	//	  matches example.com and all of its subdomains;
+	//	- a glob containing '*', '?' or '[', such as "api-*.example.com",
+	//	  with the syntax of path.Match.
+	//
+	// Patterns are matched case-insensitively against the host key,
+	// which is the Host field of the request plan, when the plugin first
+	// sees the host. A host key which includes a port also matches the
+	// patterns which match it without the port. If more than one pattern
+	// matches, an exact pattern wins over a CIDR block, a CIDR block
+	// over a suffix, and a suffix over a glob. Among patterns of the
+	// same kind, the longest wins. Hosts which match no pattern use
+	// Latency.
+	//
+	// In per-connection mode, the Machines kept for each connection
+	// always use Latency.
+	HostLatency map[string]MachineConfig
+
+	// HeaderLatency makes a Transport measure the latency of a round
+	// trip only until the response headers arrive, so that the time the
+	// caller spends reading and processing the body is not charged to
+	// the host, and a round trip whose body is never closed still
+	// produces a sample. It has no effect on the httpx plugin, which
+	// always includes reading the body.
+	//
+	// With HeaderLatency set, thresholds such as AbsThreshold, and the
+	// traces recorded by the Recorder, mean something different for a
+	// Transport than for the httpx plugin, so do not share them between
+	// the two.
+	HeaderLatency bool
+
+	// Recorder optionally receives a Record describing every request
+	// attempt observed by the plugin. If nil, no records are produced.
+	//
+	// Use a FileRecorder to write records to a file in JSON Lines
+	// format.
+	Recorder Recorder
+
+	// Include optionally limits the hosts managed by the plugin to those
+	// whose host key matches at least one of its host patterns, which
+	// have the same syntax as the keys of HostLatency. If Include is
+	// empty, every host not excluded by Exclude is managed.
+	//
+	// The plugin leaves the request attempts to hosts it does not manage
+	// alone: it keeps no Machine for them, never sets the Close field
+	// of their requests, and stores no Decision for them.
+	Include []string
+
+	// Exclude optionally prevents the plugin from managing hosts whose
+	// host key matches any of its host patterns, such as sidecars on
+	// localhost, streaming endpoints, or metadata services. Exclude
+	// takes precedence over Include.
+	Exclude []string
+
+	// PerConnection enables per-connection mode. In per-connection
+	// mode, the plugin identifies each pooled connection by its local
+	// and remote addresses, and keeps a Machine configured by Latency
+	// for each connection as well as for each host. The plugin then
+	// requests that a connection be closed only when a request attempt
+	// is about to be sent on a connection whose own Machine is in the
+	// Closing state, rather than closing every connection to a host
+	// whose Machine is in the Closing state. Host Machines are still
+	// updated and reported, but do not cause connections to be closed.
+	//
+	// Because the connection used by an attempt is only known once the
+	// attempt is under way, closing connections in per-connection mode
+	// is best-effort in the same way as recycling connections. See
+	// RecycleConfig.
+	PerConnection bool
+
+	// Pool optionally holds the connections used by the client. If it
+	// is not nil, the plugin closes all idle connections in Pool to a
+	// host as soon as the host's Machine enters the Closing state,
+	// instead of waiting for each of them to be picked for a request
+	// attempt. Over HTTP/2, where net/http stops reusing the connection
+	// shared by all requests to the host once a request with the Close
+	// field set has been sent on it, the plugin also retires the host's
+	// connections in Pool.
+	//
+	// Pool only has an effect if it is the transport which actually
+	// sends the client's requests. See the Pool documentation.
+	Pool *Pool
+
+	// Quarantine optionally dials the client's connections. If it is not
+	// nil, the plugin quarantines the remote address of every connection
+	// it closes for being slow, and of every peer ejected as an outlier,
+	// so that the replacement connections are dialed to other servers.
+	//
+	// Quarantine only has an effect if its DialContext method dials the
+	// client's connections. See the Quarantine documentation.
+	Quarantine *Quarantine
+
+	// Strategy specifies how the plugin gets rid of the connections to
+	// a host whose Machine is in the Closing state, for hosts which are
+	// not listed in Strategies. The zero value is StrategyClose. In
+	// per-connection mode, connections are always closed with
+	// StrategyClose.
+	Strategy Strategy
+
+	// Strategies optionally overrides Strategy for individual hosts. It
+	// is keyed by the host key, which is the Host field of the request
+	// plan.
+	Strategies map[string]Strategy
+
+	// Recycle specifies when to recycle connections regardless of
+	// latency, based on their age and the number of requests sent on
+	// them. The zero value disables recycling.
+	Recycle RecycleConfig
+
+	// DNS specifies how to watch for DNS changes which remove the
+	// addresses of servers the client is connected to. The zero value
+	// disables DNS watching.
+	//
+	// If DNS watching is enabled, call the Plugin's Close method to stop
+	// it when the plugin is no longer needed. Since OnClient and
+	// OnHandlers return no Plugin, they do not watch DNS, and log a
+	// warning if DNS watching is enabled.
+	DNS DNSConfig
+
+	// Outliers specifies how to detect and eject peers of a host which
+	// are much slower than the host's other peers. The zero value
+	// disables outlier detection.
+	Outliers OutlierConfig
+
+	// MaxHosts is the maximum number of hosts for which the plugin keeps
+	// a Machine and its statistics. When a new host would exceed the
+	// maximum, the least recently used host is evicted. A host which is
+	// seen again after being evicted starts over with a new Machine. If
+	// the Config has a Pool, the evicted host's connection pool in the
+	// Pool is also discarded. If MaxHosts is zero or negative, the
+	// number of hosts is unlimited.
+	MaxHosts int
+
+	// HostIdleTTL is the time after which a host which has not been
+	// sent any request attempt is evicted, in the same way as hosts
+	// evicted due to MaxHosts. If HostIdleTTL is zero or negative, idle
+	// hosts are not evicted.
+	HostIdleTTL time.Duration
+
+	// HistorySize is the number of recent state transitions and close
+	// decisions kept for each host, retrievable through the Plugin's
+	// History method. If zero, DefaultHistorySize is used. If negative,
+	// no history is kept.
+	HistorySize int
 }
 
 // OnClient installs the reconnx plugin onto an httpx.Client.
@@ -37,7 +216,45 @@ type Config struct {
 // handler group is not nil, OnClient adds the reconnx plugin into the
 // existing handler group. (Be aware of this behavior if you are sharing
 // a handler group among multiple clients.)
+//
+// Use Install instead of OnClient to obtain a Plugin handle for
+// introspecting the installed plugin, or to enable DNS watching.
 func OnClient(client *httpx.Client, config Config) *httpx.Client {
+	OnHandlers(clientHandlers(client), config)
+
+	return client
+}
+
+// OnHandlers installs the reconnx plugin onto an httpx.HandlerGroup.
+//
+// The handler group may not be nil - if it is, a panic will ensue.
+//
+// Use InstallHandlers instead of OnHandlers to obtain a Plugin handle
+// for introspecting the installed plugin, or to enable DNS watching.
+func OnHandlers(handlers *httpx.HandlerGroup, config Config) *httpx.HandlerGroup {
+	h := installHandlers(handlers, config)
+	if h.dns != nil {
+		logMessage(h, LevelWarn, "DNS watching disabled without plugin handle",
+			nil,
+			"reconnx: DNS watching is disabled, since it can only be stopped through the Plugin returned by Install, InstallHandlers or NewTransport")
+		h.dns = nil
+	}
+
+	return handlers
+}
+
+// Install installs the reconnx plugin onto an httpx.Client in the same
+// manner as OnClient, and returns a Plugin handle for the installed
+// plugin.
+//
+// The client may not be nil - if it is, a panic will ensue.
+func Install(client *httpx.Client, config Config) *Plugin {
+	return InstallHandlers(clientHandlers(client), config)
+}
+
+// clientHandlers returns the client's handler group, creating it if the
+// client has none.
+func clientHandlers(client *httpx.Client) *httpx.HandlerGroup {
 	if client == nil {
 		panic(nilClientMsg)
 	}
@@ -48,30 +265,114 @@ func OnClient(client *httpx.Client, config Config) *httpx.Client {
 		client.Handlers = handlers
 	}
 
-	OnHandlers(handlers, config)
-
-	return client
+	return handlers
 }
 
-// OnHandlers installs the reconnx plugin onto an httpx.HandlerGroup.
+// InstallHandlers installs the reconnx plugin onto an
+// httpx.HandlerGroup in the same manner as OnHandlers, and returns a
+// Plugin handle for the installed plugin.
 //
 // The handler group may not be nil - if it is, a panic will ensue.
-func OnHandlers(handlers *httpx.HandlerGroup, config Config) *httpx.HandlerGroup {
+func InstallHandlers(handlers *httpx.HandlerGroup, config Config) *Plugin {
+	h := installHandlers(handlers, config)
+	startWatching(h)
+
+	return &Plugin{h: h}
+}
+
+// installHandlers adds a new handler to the handler group without
+// starting its background work.
+func installHandlers(handlers *httpx.HandlerGroup, config Config) *handler {
 	if handlers == nil {
 		panic(nilHandlerGroupMsg)
 	}
 
+	handler := newHandler(config)
+	handlers.PushBack(httpx.BeforeExecutionStart, handler)
+	handlers.PushBack(httpx.BeforeAttempt, handler)
+	handlers.PushBack(httpx.AfterAttempt, handler)
+
+	return handler
+}
+
+// TryInstall validates config and, if it is valid, installs the reconnx
+// plugin onto an httpx.Client in the same manner as Install.
+//
+// Unlike Install, TryInstall does not panic if client is nil, and does
+// not install a plugin which would never close a connection. Instead,
+// it returns an error, which is a *ConfigError if config is invalid.
+func TryInstall(client *httpx.Client, config Config) (*Plugin, error) {
+	if client == nil {
+		return nil, errors.New(nilClientMsg)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return Install(client, config), nil
+}
+
+// TryInstallHandlers validates config and, if it is valid, installs the
+// reconnx plugin onto an httpx.HandlerGroup in the same manner as
+// InstallHandlers.
+//
+// Unlike InstallHandlers, TryInstallHandlers does not panic if handlers
+// is nil, and does not install a plugin which would never close a
+// connection. Instead, it returns an error, which is a *ConfigError if
+// config is invalid.
+func TryInstallHandlers(handlers *httpx.HandlerGroup, config Config) (*Plugin, error) {
+	if handlers == nil {
+		return nil, errors.New(nilHandlerGroupMsg)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return InstallHandlers(handlers, config), nil
+}
+
+// newHandler constructs a handler with the given configuration, filling
+// in defaults for the optional fields.
+func newHandler(config Config) *handler {
 	if config.Logger == nil {
 		config.Logger = NopLogger{}
 	}
+	if config.Listener == nil {
+		config.Listener = NopListener{}
+	}
+	if config.Metrics == nil {
+		config.Metrics = NopMetrics{}
+	}
+	if config.HistorySize == 0 {
+		config.HistorySize = DefaultHistorySize
+	}
 
-	handler := &handler{
+	h := &handler{
 		Config:      config,
-		hostLatency: map[string]Machine{},
+		hostLatency: map[string]*hostEntry{},
+		filter:      newHostFilter(config.Include, config.Exclude),
 	}
-	handlers.PushBack(httpx.BeforeExecutionStart, handler)
-	handlers.PushBack(httpx.BeforeAttempt, handler)
-	handlers.PushBack(httpx.AfterAttempt, handler)
+	if config.Recycle.enabled() || config.DNS.enabled() || config.PerConnection || config.Outliers.enabled() || config.Quarantine != nil {
+		h.conns = newConnTracker(config.Recycle)
+	}
+	if config.Outliers.enabled() {
+		h.outliers = newOutlierDetector(config.Outliers)
+	}
+	if config.PerConnection {
+		h.conns.newMachine = func() Machine {
+			return NewMachine(config.Latency)
+		}
+	}
+	if config.DNS.enabled() {
+		h.dns = newDNSWatcher(h, config.DNS)
+	}
+	return h
+}
 
-	return handlers
+// startWatching starts the handler's background work, which must be
+// stopped by the Close method of a Plugin for the handler.
+func startWatching(h *handler) {
+	if h.dns != nil {
+		h.dns.start()
+	}
 }
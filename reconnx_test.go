@@ -6,9 +6,11 @@ package reconnx
 
 import (
 	"testing"
+	"time"
 
 	"github.com/gogama/httpx"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestOnClient(t *testing.T) {
@@ -50,4 +52,92 @@ func TestOnHandlers(t *testing.T) {
 		h := &httpx.HandlerGroup{}
 		OnHandlers(h, Config{Logger: &NopLogger{}})
 	})
+	t.Run("DNS", func(t *testing.T) {
+		l := newMockLogger(t)
+		l.On("Printf", "reconnx: DNS watching is disabled, since it can only be stopped through the Plugin returned by Install, InstallHandlers or NewTransport").Once()
+
+		OnHandlers(&httpx.HandlerGroup{}, Config{Logger: l, DNS: DNSConfig{Interval: time.Minute}})
+
+		l.AssertExpectations(t)
+	})
+}
+
+func TestInstall(t *testing.T) {
+	t.Run("nil Client", func(t *testing.T) {
+		assert.PanicsWithValue(t, nilClientMsg, func() {
+			Install(nil, Config{})
+		})
+	})
+	t.Run("client has nil Handlers", func(t *testing.T) {
+		cl := &httpx.Client{}
+		p := Install(cl, Config{})
+		assert.NotNil(t, cl.Handlers)
+		require.NotNil(t, p)
+		assert.Equal(t, NopLogger{}, p.h.Logger)
+		assert.Equal(t, NopListener{}, p.h.Listener)
+	})
+}
+
+func TestInstallHandlers(t *testing.T) {
+	t.Run("nil HandlerGroup", func(t *testing.T) {
+		assert.PanicsWithValue(t, nilHandlerGroupMsg, func() {
+			InstallHandlers(nil, Config{})
+		})
+	})
+	t.Run("everything", func(t *testing.T) {
+		l := &NopLogger{}
+		p := InstallHandlers(&httpx.HandlerGroup{}, Config{Logger: l})
+		require.NotNil(t, p)
+		assert.Same(t, l, p.h.Logger)
+		assert.NotNil(t, p.h.hostLatency)
+	})
+	t.Run("DNS", func(t *testing.T) {
+		p := InstallHandlers(&httpx.HandlerGroup{}, Config{DNS: DNSConfig{Interval: time.Minute}})
+		require.NotNil(t, p.h.dns)
+
+		p.Close()
+	})
+}
+
+func TestTryInstall(t *testing.T) {
+	valid := Config{Latency: MachineConfig{AbsThreshold: 100.0, ClosingStreak: 1, ClosingCount: 1}}
+	t.Run("nil Client", func(t *testing.T) {
+		p, err := TryInstall(nil, valid)
+		assert.Nil(t, p)
+		assert.EqualError(t, err, nilClientMsg)
+	})
+	t.Run("invalid Config", func(t *testing.T) {
+		cl := &httpx.Client{}
+		p, err := TryInstall(cl, Config{})
+		assert.Nil(t, p)
+		assert.IsType(t, &ConfigError{}, err)
+		assert.Nil(t, cl.Handlers)
+	})
+	t.Run("everything", func(t *testing.T) {
+		cl := &httpx.Client{}
+		p, err := TryInstall(cl, valid)
+		require.NoError(t, err)
+		require.NotNil(t, p)
+		assert.NotNil(t, cl.Handlers)
+	})
+}
+
+func TestTryInstallHandlers(t *testing.T) {
+	valid := Config{Recycle: RecycleConfig{MaxRequests: 100}}
+	t.Run("nil HandlerGroup", func(t *testing.T) {
+		p, err := TryInstallHandlers(nil, valid)
+		assert.Nil(t, p)
+		assert.EqualError(t, err, nilHandlerGroupMsg)
+	})
+	t.Run("invalid Config", func(t *testing.T) {
+		p, err := TryInstallHandlers(&httpx.HandlerGroup{}, Config{})
+		assert.Nil(t, p)
+		assert.IsType(t, &ConfigError{}, err)
+	})
+	t.Run("everything", func(t *testing.T) {
+		p, err := TryInstallHandlers(&httpx.HandlerGroup{}, valid)
+		require.NoError(t, err)
+		require.NotNil(t, p)
+		assert.NotNil(t, p.h.conns)
+	})
 }
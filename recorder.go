@@ -0,0 +1,79 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"time"
+
+	"github.com/gogama/httpx/transient"
+)
+
+// A Record describes one request attempt observed by the reconnx
+// plugin. Records are produced after each attempt ends, and are
+// suitable for encoding as JSON, one record per line, to build a trace
+// for offline analysis.
+type Record struct {
+	// Time is the time at which the attempt ended.
+	Time time.Time `json:"time"`
+
+	// Host is the host key identifying the Machine that received the
+	// attempt's latency sample.
+	Host string `json:"host"`
+
+	// Attempt is the zero-based attempt number within the execution.
+	Attempt int `json:"attempt"`
+
+	// Latency is the measured latency of the attempt in milliseconds.
+	Latency float64 `json:"latency_ms"`
+
+	// ErrorClass classifies the attempt error, if any. It is empty if
+	// the attempt did not end in error, and is otherwise one of
+	// "timeout", "conn_refused", "conn_reset" or "other".
+	ErrorClass string `json:"error_class,omitempty"`
+
+	// StatusCode is the HTTP status code of the attempt's response, or
+	// zero if no response was received.
+	StatusCode int `json:"status_code,omitempty"`
+
+	// Close indicates whether reconnx requested that the attempt's
+	// connection be closed.
+	Close bool `json:"close"`
+
+	// Prev is the host Machine's state before the latency sample was
+	// received.
+	Prev State `json:"prev"`
+
+	// Next is the host Machine's state after the latency sample was
+	// received.
+	Next State `json:"next"`
+}
+
+// A Recorder receives a Record for every request attempt observed by
+// the reconnx plugin.
+//
+// The Record method is called synchronously on the request path, so
+// implementations must return quickly and must never block. They must
+// also be safe for concurrent use by multiple goroutines.
+type Recorder interface {
+	// Record receives the record of a single request attempt.
+	Record(rec Record)
+}
+
+func errorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	switch transient.Categorize(err) {
+	case transient.Timeout:
+		return "timeout"
+	case transient.ConnRefused:
+		return "conn_refused"
+	case transient.ConnReset:
+		return "conn_reset"
+	default:
+		return "other"
+	}
+}
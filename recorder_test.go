@@ -0,0 +1,85 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord_JSON(t *testing.T) {
+	rec := Record{
+		Time:       time.Date(2021, 9, 23, 10, 11, 12, 0, time.UTC),
+		Host:       "foo.com",
+		Attempt:    2,
+		Latency:    123.0,
+		ErrorClass: "timeout",
+		StatusCode: 503,
+		Close:      true,
+		Prev:       Watching,
+		Next:       Closing,
+	}
+
+	b, err := json.Marshal(rec)
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"time": "2021-09-23T10:11:12Z",
+		"host": "foo.com",
+		"attempt": 2,
+		"latency_ms": 123,
+		"error_class": "timeout",
+		"status_code": 503,
+		"close": true,
+		"prev": "Watching",
+		"next": "Closing"
+	}`, string(b))
+	var rec2 Record
+	err = json.Unmarshal(b, &rec2)
+	require.NoError(t, err)
+	assert.Equal(t, rec, rec2)
+}
+
+func TestErrorClass(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"nil", nil, ""},
+		{"timeout", &net.DNSError{IsTimeout: true}, "timeout"},
+		{"conn_refused", syscall.ECONNREFUSED, "conn_refused"},
+		{"conn_reset", syscall.ECONNRESET, "conn_reset"},
+		{"other", errors.New("foo"), "other"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, errorClass(testCase.err))
+		})
+	}
+}
+
+type mockRecorder struct {
+	mock.Mock
+}
+
+func newMockRecorder(t *testing.T) *mockRecorder {
+	m := &mockRecorder{}
+	m.Test(t)
+	return m
+}
+
+func (m *mockRecorder) Record(rec Record) {
+	m.Called(rec)
+}
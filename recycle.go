@@ -0,0 +1,315 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// recycleIdleTTL is how long a tracked connection may go unused
+	// before the plugin forgets about it, on the assumption that it has
+	// been closed.
+	recycleIdleTTL = 10 * time.Minute
+
+	// recycleSweepInterval is the minimum time between sweeps of the
+	// tracked connections.
+	recycleSweepInterval = time.Minute
+)
+
+// A RecycleConfig specifies when to recycle pooled connections
+// regardless of latency. Recycling connections periodically ensures
+// that DNS changes and load balancer scale-outs are picked up even when
+// every host is performing well.
+//
+// The plugin identifies each connection by its local and remote
+// addresses, as reported by the net/http/httptrace package. When a
+// request attempt is about to be sent on a connection which has
+// exceeded its maximum age or maximum number of requests, the plugin
+// sets the Close field of the attempt's request so that the connection
+// is closed after the attempt ends.
+//
+// Recycling is best-effort: if the underlying RoundTripper sends a copy
+// of the request rather than the request itself, as the standard
+// library http.Transport does for some requests with a body, the
+// connection is not closed, and the plugin tries again on the
+// connection's next request.
+type RecycleConfig struct {
+	// MaxAge is the maximum age of a connection. The age of a
+	// connection is measured from the first time the plugin sees it.
+	// If MaxAge is zero or negative, connections are not recycled due
+	// to their age.
+	MaxAge time.Duration
+
+	// MaxRequests is the maximum number of request attempts sent on a
+	// connection. If MaxRequests is zero, connections are not recycled
+	// due to the number of requests sent on them.
+	MaxRequests uint
+
+	// Jitter randomizes the limits of each connection, so that
+	// connections opened at the same time are not all recycled at the
+	// same time. Each connection's limits are chosen uniformly at
+	// random within the range of plus or minus Jitter times MaxAge and
+	// MaxRequests. Jitter is clamped to the range [0, 1].
+	Jitter float64
+}
+
+func (rc RecycleConfig) enabled() bool {
+	return rc.MaxAge > 0 || rc.MaxRequests > 0
+}
+
+// A recycleCause explains why a connection is being recycled.
+type recycleCause string
+
+const (
+	recycleNone        recycleCause = ""
+	recycleMaxAge      recycleCause = "max_age"
+	recycleMaxRequests recycleCause = "max_requests"
+	recycleDNS         recycleCause = "dns"
+	recycleOutlier     recycleCause = "outlier"
+)
+
+// A connTracker tracks the age, request count, and peer of pooled
+// connections for the recycling policy and the DNS watcher.
+type connTracker struct {
+	config     RecycleConfig
+	rand       func() float64
+	newMachine func() Machine
+	lock       sync.Mutex
+	conns      map[string]*trackedConn
+	lastSweep  time.Time
+}
+
+type trackedConn struct {
+	peer        connPeer
+	machine     Machine
+	stale       bool
+	start       time.Time
+	lastUsed    time.Time
+	requests    uint
+	maxAge      time.Duration
+	maxRequests uint
+}
+
+func newConnTracker(config RecycleConfig) *connTracker {
+	config.Jitter = math.Max(0, math.Min(1, config.Jitter))
+	return &connTracker{
+		config: config,
+		rand:   rand.Float64,
+		conns:  map[string]*trackedConn{},
+	}
+}
+
+// A connPeer identifies the server at the other end of a connection.
+type connPeer struct {
+	// poolHost is the Host field of the URL of the requests sent on the
+	// connection, which may include a port.
+	poolHost string
+
+	// ip is the remote IP address of the connection.
+	ip string
+}
+
+// hostname returns the host name of the peer, without any port.
+func (cp connPeer) hostname() string {
+	return stripPort(cp.poolHost)
+}
+
+// use records that a request attempt is about to be sent on the
+// connection identified by id, and returns the reason the connection
+// should be recycled, if any. Connections which are recycled are
+// forgotten.
+func (ct *connTracker) use(id string, peer connPeer, reused bool, now time.Time) recycleCause {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	ct.sweep(now)
+	tc := ct.conns[id]
+	if tc == nil || !reused {
+		tc = &trackedConn{
+			peer:        peer,
+			machine:     ct.machine(),
+			start:       now,
+			maxAge:      time.Duration(ct.jitter(float64(ct.config.MaxAge))),
+			maxRequests: uint(math.Max(1, math.Round(ct.jitter(float64(ct.config.MaxRequests))))),
+		}
+		ct.conns[id] = tc
+	}
+	tc.lastUsed = now
+	tc.requests++
+
+	cause := recycleNone
+	if tc.stale {
+		cause = recycleDNS
+	} else if ct.config.MaxAge > 0 && now.Sub(tc.start) >= tc.maxAge {
+		cause = recycleMaxAge
+	} else if ct.config.MaxRequests > 0 && tc.requests >= tc.maxRequests {
+		cause = recycleMaxRequests
+	}
+	if cause != recycleNone {
+		delete(ct.conns, id)
+	}
+	return cause
+}
+
+func (ct *connTracker) machine() Machine {
+	if ct.newMachine == nil {
+		return nil
+	}
+
+	return ct.newMachine()
+}
+
+// connMachine returns the Machine of the connection identified by id,
+// or nil if the connection is not tracked or connections do not have
+// their own Machines.
+func (ct *connTracker) connMachine(id string) Machine {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	if tc := ct.conns[id]; tc != nil {
+		return tc.machine
+	}
+	return nil
+}
+
+// forget stops tracking the connection identified by id.
+func (ct *connTracker) forget(id string) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	delete(ct.conns, id)
+}
+
+// markStale marks the tracked connections to hostname whose remote IP
+// is among the removed addresses, so they are recycled on their next
+// use. It returns the pool hosts of the connections newly marked.
+func (ct *connTracker) markStale(hostname string, removed []string) []string {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	gone := make(map[string]bool, len(removed))
+	for _, addr := range removed {
+		gone[addr] = true
+	}
+	var poolHosts []string
+	marked := map[string]bool{}
+	for _, tc := range ct.conns {
+		if tc.stale || tc.peer.hostname() != hostname || !gone[tc.peer.ip] {
+			continue
+		}
+		tc.stale = true
+		if !marked[tc.peer.poolHost] {
+			marked[tc.peer.poolHost] = true
+			poolHosts = append(poolHosts, tc.peer.poolHost)
+		}
+	}
+	return poolHosts
+}
+
+// hostnames returns the distinct host names of the tracked connections
+// which are not literal IP addresses.
+func (ct *connTracker) hostnames() []string {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	seen := map[string]bool{}
+	var hostnames []string
+	for _, tc := range ct.conns {
+		hostname := tc.peer.hostname()
+		if seen[hostname] || net.ParseIP(hostname) != nil {
+			continue
+		}
+		seen[hostname] = true
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	return hostnames
+}
+
+func (ct *connTracker) jitter(v float64) float64 {
+	if ct.config.Jitter == 0 {
+		return v
+	}
+
+	return v * (1 + ct.config.Jitter*(2*ct.rand()-1))
+}
+
+func (ct *connTracker) sweep(now time.Time) {
+	if now.Sub(ct.lastSweep) < recycleSweepInterval {
+		return
+	}
+
+	ct.lastSweep = now
+	for id, tc := range ct.conns {
+		if now.Sub(tc.lastUsed) >= recycleIdleTTL {
+			delete(ct.conns, id)
+		}
+	}
+}
+
+// connID returns the identity of a connection.
+func connID(conn net.Conn) string {
+	return conn.LocalAddr().String() + "->" + conn.RemoteAddr().String()
+}
+
+// remoteIP returns the remote IP address of a connection.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// traceConnection returns a shallow copy of r whose context reports to
+// the handler's connection tracker which connection the request is sent
+// on, and records the connection in the attempt state.
+//
+// If the connection should be recycled, because it exceeded a limit in
+// the RecycleConfig, because the DNS watcher found that its peer is
+// gone, or because its peer is an ejected outlier, the copy's Close
+// field is set and the attempt is marked
+// as recycled. In per-connection mode, the copy's Close field is also
+// set if the connection's own Machine is in the Closing state.
+func traceConnection(h *handler, host string, attempt int, r *http.Request, as *attemptState) *http.Request {
+	var r2 *http.Request
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn == nil {
+				return
+			}
+			id := connID(info.Conn)
+			peer := connPeer{poolHost: r2.URL.Host, ip: remoteIP(info.Conn)}
+			cause := h.conns.use(id, peer, info.Reused, time.Now())
+			if cause == recycleNone && h.outliers != nil && h.outliers.isEjected(peer) {
+				cause = recycleOutlier
+				h.conns.forget(id)
+			}
+			as.conn, as.peer = id, peer
+			if cause != recycleNone && !r2.Close {
+				logMessage(h, LevelDebug, "connection will be recycled after attempt ends",
+					[]Field{{FieldHost, host}, {FieldAttempt, attempt}, {FieldReason, string(cause)}},
+					"reconnx: a connection to %s will be recycled after attempt %d ends (%s)", host, attempt, cause)
+				r2.Close = true
+				as.recycled = true
+				h.Metrics.Counter(MetricRecycles, 1, Tag{TagHost, host}, Tag{TagCause, string(cause)})
+			}
+			if cm := h.conns.connMachine(id); cm != nil && cm.State() == Closing && !as.close {
+				r2.Close = true
+				requestClose(h, host, attempt, as, HistoryConnCloseRequest, machineStats(cm, Closing))
+			}
+		},
+	}
+	r2 = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+	return r2
+}
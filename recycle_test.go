@@ -0,0 +1,164 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogama/httpx"
+	"github.com/gogama/httpx/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecycleConfig_enabled(t *testing.T) {
+	assert.False(t, RecycleConfig{}.enabled())
+	assert.False(t, RecycleConfig{MaxAge: -1, Jitter: 0.5}.enabled())
+	assert.True(t, RecycleConfig{MaxAge: time.Second}.enabled())
+	assert.True(t, RecycleConfig{MaxRequests: 1}.enabled())
+}
+
+func TestConnTracker(t *testing.T) {
+	now := time.Now()
+	t.Run("MaxRequests", func(t *testing.T) {
+		ct := newConnTracker(RecycleConfig{MaxRequests: 3})
+
+		assert.Equal(t, recycleNone, ct.use("a", connPeer{}, false, now))
+		assert.Equal(t, recycleNone, ct.use("a", connPeer{}, true, now))
+		assert.Equal(t, recycleNone, ct.use("b", connPeer{}, true, now))
+		assert.Equal(t, recycleMaxRequests, ct.use("a", connPeer{}, true, now))
+		assert.Equal(t, recycleNone, ct.use("a", connPeer{}, true, now))
+		assert.Len(t, ct.conns, 2)
+	})
+	t.Run("MaxAge", func(t *testing.T) {
+		ct := newConnTracker(RecycleConfig{MaxAge: time.Minute})
+
+		assert.Equal(t, recycleNone, ct.use("a", connPeer{}, false, now))
+		assert.Equal(t, recycleNone, ct.use("a", connPeer{}, true, now.Add(59*time.Second)))
+		assert.Equal(t, recycleMaxAge, ct.use("a", connPeer{}, true, now.Add(time.Minute)))
+		assert.Empty(t, ct.conns)
+	})
+	t.Run("NotReused", func(t *testing.T) {
+		ct := newConnTracker(RecycleConfig{MaxRequests: 2})
+
+		assert.Equal(t, recycleNone, ct.use("a", connPeer{}, false, now))
+		assert.Equal(t, recycleNone, ct.use("a", connPeer{}, false, now))
+		assert.Equal(t, recycleMaxRequests, ct.use("a", connPeer{}, true, now))
+	})
+	t.Run("Jitter", func(t *testing.T) {
+		ct := newConnTracker(RecycleConfig{MaxAge: 10 * time.Second, MaxRequests: 10, Jitter: 2.0})
+		assert.Equal(t, 1.0, ct.config.Jitter)
+		ct = newConnTracker(RecycleConfig{MaxAge: 10 * time.Second, MaxRequests: 10, Jitter: 0.5})
+		r := 0.0
+		ct.rand = func() float64 { return r }
+
+		ct.use("low", connPeer{}, false, now)
+		r = 1.0
+		ct.use("high", connPeer{}, false, now)
+
+		assert.Equal(t, 5*time.Second, ct.conns["low"].maxAge)
+		assert.Equal(t, uint(5), ct.conns["low"].maxRequests)
+		assert.Equal(t, 15*time.Second, ct.conns["high"].maxAge)
+		assert.Equal(t, uint(15), ct.conns["high"].maxRequests)
+	})
+	t.Run("Hostnames", func(t *testing.T) {
+		ct := newConnTracker(RecycleConfig{})
+
+		ct.use("a", connPeer{poolHost: "foo:443"}, false, now)
+		ct.use("b", connPeer{poolHost: "foo"}, false, now)
+		ct.use("c", connPeer{poolHost: "bar"}, false, now)
+		ct.use("d", connPeer{poolHost: "10.0.0.1:80"}, false, now)
+		ct.use("e", connPeer{poolHost: "[::1]"}, false, now)
+
+		assert.Equal(t, []string{"bar", "foo"}, ct.hostnames())
+	})
+	t.Run("MarkStale", func(t *testing.T) {
+		ct := newConnTracker(RecycleConfig{})
+		ct.use("a", connPeer{poolHost: "foo:443", ip: "10.0.0.1"}, false, now)
+		ct.use("b", connPeer{poolHost: "foo:80", ip: "10.0.0.1"}, false, now)
+		ct.use("c", connPeer{poolHost: "foo:443", ip: "10.0.0.2"}, false, now)
+		ct.use("d", connPeer{poolHost: "bar", ip: "10.0.0.1"}, false, now)
+
+		poolHosts := ct.markStale("foo", []string{"10.0.0.1", "10.0.0.3"})
+
+		assert.ElementsMatch(t, []string{"foo:443", "foo:80"}, poolHosts)
+		assert.Empty(t, ct.markStale("foo", []string{"10.0.0.1"}))
+		assert.Equal(t, recycleDNS, ct.use("a", connPeer{}, true, now))
+		assert.Equal(t, recycleDNS, ct.use("b", connPeer{}, true, now))
+		assert.Equal(t, recycleNone, ct.use("c", connPeer{}, true, now))
+		assert.Equal(t, recycleNone, ct.use("d", connPeer{}, true, now))
+	})
+	t.Run("Sweep", func(t *testing.T) {
+		ct := newConnTracker(RecycleConfig{MaxRequests: 10})
+
+		ct.use("a", connPeer{}, false, now)
+		ct.use("b", connPeer{}, false, now.Add(recycleIdleTTL/2))
+		ct.use("c", connPeer{}, false, now.Add(recycleIdleTTL))
+
+		assert.Len(t, ct.conns, 2)
+		assert.NotContains(t, ct.conns, "a")
+	})
+}
+
+func TestRecycle(t *testing.T) {
+	var dialed int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&dialed, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	config := Config{Recycle: RecycleConfig{MaxRequests: 2}}
+
+	t.Run("Client", func(t *testing.T) {
+		atomic.StoreInt32(&dialed, 0)
+		cl := &httpx.Client{HTTPDoer: &http.Client{Transport: &http.Transport{}}}
+		Install(cl, config)
+		var decisions []Decision
+		cl.Handlers.PushBack(httpx.AfterAttempt, httpx.HandlerFunc(func(_ httpx.Event, e *request.Execution) {
+			d, ok := DecisionOf(e)
+			require.True(t, ok)
+			decisions = append(decisions, d)
+		}))
+
+		for i := 0; i < 5; i++ {
+			_, err := cl.Get(server.URL)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, int32(3), atomic.LoadInt32(&dialed))
+		require.Len(t, decisions, 5)
+		for i, d := range decisions {
+			assert.Equal(t, i%2 == 1, d.Recycled, "decision %d", i)
+			assert.Equal(t, i%2 == 1, d.Close, "decision %d", i)
+		}
+	})
+	t.Run("Transport", func(t *testing.T) {
+		atomic.StoreInt32(&dialed, 0)
+		cl := &http.Client{Transport: NewTransport(&http.Transport{}, config)}
+
+		for i := 0; i < 5; i++ {
+			get(t, cl, server.URL)
+		}
+
+		assert.Equal(t, int32(3), atomic.LoadInt32(&dialed))
+	})
+}
+
+func TestConnPeer_hostname(t *testing.T) {
+	assert.Equal(t, "foo", connPeer{poolHost: "foo"}.hostname())
+	assert.Equal(t, "foo", connPeer{poolHost: "foo:80"}.hostname())
+	assert.Equal(t, "::1", connPeer{poolHost: "[::1]:80"}.hostname())
+	assert.Equal(t, "::1", connPeer{poolHost: "[::1]"}.hostname())
+}
@@ -0,0 +1,16 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package slogger adapts the Go standard library's structured logger,
+log/slog, to the reconnx.StructuredLogger interface.
+
+The package requires Go 1.21 or later. With earlier Go versions it is
+empty.
+
+	cfg := reconnx.Config{
+		StructuredLogger: slogger.New(slog.Default()),
+	}
+*/
+package slogger
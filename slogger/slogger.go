@@ -0,0 +1,62 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package slogger
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gogama/reconnx"
+)
+
+// Logger implements the reconnx.StructuredLogger interface by sending
+// messages to a slog.Logger.
+type Logger struct {
+	l *slog.Logger
+}
+
+// New returns a Logger which sends messages to l. If l is nil, the
+// default slog.Logger, as returned by slog.Default, is used.
+func New(l *slog.Logger) *Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return &Logger{l: l}
+}
+
+// Log sends a message to the underlying slog.Logger, converting the
+// reconnx level to the equivalent slog level and each field to a
+// slog attribute.
+func (l *Logger) Log(level reconnx.Level, msg string, fields ...reconnx.Field) {
+	ctx := context.Background()
+	lvl := Level(level)
+	if !l.l.Enabled(ctx, lvl) {
+		return
+	}
+
+	attrs := make([]slog.Attr, len(fields))
+	for i := range fields {
+		attrs[i] = slog.Any(fields[i].Key, fields[i].Value)
+	}
+	l.l.LogAttrs(ctx, lvl, msg, attrs...)
+}
+
+// Level converts a reconnx level to the equivalent slog level.
+func Level(level reconnx.Level) slog.Level {
+	switch level {
+	case reconnx.LevelDebug:
+		return slog.LevelDebug
+	case reconnx.LevelInfo:
+		return slog.LevelInfo
+	case reconnx.LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package slogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/gogama/reconnx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		l := New(nil)
+		assert.Same(t, slog.Default(), l.l)
+	})
+	t.Run("NotNil", func(t *testing.T) {
+		sl := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+		l := New(sl)
+		assert.Same(t, sl, l.l)
+	})
+}
+
+func TestLogger_Log(t *testing.T) {
+	var b bytes.Buffer
+	sl := slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	var l reconnx.StructuredLogger = New(sl)
+
+	l.Log(reconnx.LevelDebug, "ignored")
+	l.Log(reconnx.LevelInfo, "host state changed",
+		reconnx.Field{Key: reconnx.FieldHost, Value: "foo.com"},
+		reconnx.Field{Key: reconnx.FieldAttempt, Value: 2},
+		reconnx.Field{Key: reconnx.FieldPrevState, Value: reconnx.Watching},
+		reconnx.Field{Key: reconnx.FieldState, Value: reconnx.Closing})
+
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &m))
+	delete(m, "time")
+	assert.Equal(t, map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "host state changed",
+		"host":       "foo.com",
+		"attempt":    2.0,
+		"prev_state": "Watching",
+		"state":      "Closing",
+	}, m)
+}
+
+func TestLevel(t *testing.T) {
+	assert.Equal(t, slog.LevelDebug, Level(reconnx.LevelDebug))
+	assert.Equal(t, slog.LevelInfo, Level(reconnx.LevelInfo))
+	assert.Equal(t, slog.LevelWarn, Level(reconnx.LevelWarn))
+	assert.Equal(t, slog.LevelError, Level(reconnx.LevelError))
+	assert.Equal(t, slog.LevelError, Level(reconnx.Level(99)))
+}
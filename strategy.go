@@ -0,0 +1,76 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import "fmt"
+
+// A Strategy specifies how the plugin gets rid of the connections to a
+// host whose Machine is in the Closing state.
+type Strategy int
+
+const (
+	// StrategyClose closes the connection used by each request attempt
+	// as soon as the attempt ends, by setting the Close field of the
+	// attempt's request. It is the default strategy.
+	StrategyClose Strategy = iota
+
+	// StrategyDrain stops handing out the host's existing connections
+	// for new request attempts, lets the requests in flight on them
+	// finish, and then closes them. It suits long-polling and streaming
+	// clients, whose requests would otherwise be cut off.
+	//
+	// Draining requires a Pool to be set in the Config. The plugin drains
+	// connections by retiring the host's connection pool in the Pool,
+	// once each time the host's Machine enters the Closing state, and
+	// only counts connections as closed for the purposes of the host's
+	// Machine once the drain has completed.
+	//
+	// If the Pool has no connections to the host, for example because it
+	// is not the transport actually sending the client's requests, or if
+	// the drain has not completed within a minute, for example because a
+	// response body is never closed, the plugin falls back to
+	// StrategyClose until the host's Machine leaves the Closing state.
+	StrategyDrain
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategyClose:
+		return "Close"
+	case StrategyDrain:
+		return "Drain"
+	default:
+		return ""
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface. The
+// text form of a Strategy is the same as its String value.
+func (s Strategy) MarshalText() ([]byte, error) {
+	str := s.String()
+	if str == "" {
+		return nil, fmt.Errorf("reconnx: invalid strategy %d", int(s))
+	}
+	return []byte(str), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (s *Strategy) UnmarshalText(text []byte) error {
+	for c := StrategyClose; c <= StrategyDrain; c++ {
+		if c.String() == string(text) {
+			*s = c
+			return nil
+		}
+	}
+	return fmt.Errorf("reconnx: invalid strategy %q", text)
+}
+
+// strategy returns the Strategy configured for a host.
+func strategy(h *handler, host string) Strategy {
+	if s, ok := h.Strategies[host]; ok {
+		return s
+	}
+	return h.Strategy
+}
@@ -0,0 +1,47 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrategy(t *testing.T) {
+	t.Run("String", func(t *testing.T) {
+		assert.Equal(t, "Close", StrategyClose.String())
+		assert.Equal(t, "Drain", StrategyDrain.String())
+		assert.Equal(t, "", Strategy(-1).String())
+	})
+	t.Run("MarshalText", func(t *testing.T) {
+		b, err := StrategyDrain.MarshalText()
+		require.NoError(t, err)
+		assert.Equal(t, "Drain", string(b))
+		_, err = Strategy(-1).MarshalText()
+		assert.EqualError(t, err, "reconnx: invalid strategy -1")
+	})
+	t.Run("UnmarshalText", func(t *testing.T) {
+		for s := StrategyClose; s <= StrategyDrain; s++ {
+			var s2 Strategy
+			err := s2.UnmarshalText([]byte(s.String()))
+			require.NoError(t, err)
+			assert.Equal(t, s, s2)
+		}
+		var s Strategy
+		err := s.UnmarshalText([]byte("Explode"))
+		assert.EqualError(t, err, `reconnx: invalid strategy "Explode"`)
+	})
+	t.Run("Host", func(t *testing.T) {
+		h := newHandler(Config{
+			Strategy:   StrategyDrain,
+			Strategies: map[string]Strategy{"foo": StrategyClose},
+		})
+
+		assert.Equal(t, StrategyClose, strategy(h, "foo"))
+		assert.Equal(t, StrategyDrain, strategy(h, "bar"))
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A Transport is an http.RoundTripper which applies the reconnx plugin
+// to requests sent through a plain net/http client, without the httpx
+// library.
+//
+// Transport keeps a Machine for each host in the same way as the httpx
+// plugin and, while a host's Machine is in the Closing state, asks the
+// underlying RoundTripper to close the connection after each round trip
+// to the host. Every round trip is treated as attempt zero of its own
+// execution. As with the httpx plugin, the latency of a round trip is
+// measured from the start of the round trip until the response body is
+// fully read or closed, or until the round trip fails, so a round trip
+// whose body is never closed produces no sample. If the Config's
+// HeaderLatency field is set, the latency is instead measured until the
+// response headers arrive.
+//
+// Transport never modifies the requests passed to it. When it needs to
+// close a connection, or to watch which connection a request is sent on
+// for the Config's RecycleConfig, it sends a shallow copy of the
+// request instead.
+//
+// Use NewTransport to construct a Transport:
+//
+//	t := reconnx.NewTransport(nil, reconnx.Config{Latency: ...})
+//	cl := &http.Client{Transport: t}
+//
+// Transport is safe for concurrent use by multiple goroutines.
+type Transport struct {
+	base http.RoundTripper
+	h    *handler
+}
+
+// NewTransport constructs a Transport which sends requests through
+// base, applying the reconnx plugin with the given configuration.
+//
+// If base is nil, the Pool from config is used if it is set, and
+// otherwise http.DefaultTransport is used.
+func NewTransport(base http.RoundTripper, config Config) *Transport {
+	if base == nil {
+		if config.Pool != nil {
+			base = config.Pool
+		} else {
+			base = http.DefaultTransport
+		}
+	}
+
+	h := newHandler(config)
+	startWatching(h)
+
+	return &Transport{
+		base: base,
+		h:    h,
+	}
+}
+
+// Plugin returns a Plugin handle for introspecting the hosts tracked by
+// the Transport.
+func (t *Transport) Plugin() *Plugin {
+	return &Plugin{h: t.h}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL == nil {
+		if r.Body != nil {
+			_ = r.Body.Close()
+		}
+		return nil, errors.New(nilRequestURLMsg)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	if !managed(t.h, host) {
+		return t.base.RoundTrip(r)
+	}
+	as := &attemptState{start: time.Now()}
+	startAttempt(t.h, host, 0, as)
+	as.entry.usedPool(r.URL.Host)
+	if as.close && !as.drain && !r.Close {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.Close = true
+		r = r2
+	}
+	if t.h.conns != nil {
+		r = traceConnection(t.h, host, 0, r, as)
+	}
+
+	rt := &roundTrip{
+		h:    t.h,
+		host: host,
+		as:   as,
+		out: attemptOutcome{
+			poolHost: r.URL.Host,
+		},
+	}
+	resp, err := t.base.RoundTrip(r)
+	rt.out.closed = r.Close
+	if err != nil {
+		rt.finish(err)
+		return nil, err
+	}
+
+	rt.out.statusCode = resp.StatusCode
+	rt.out.http2 = resp.ProtoMajor == 2
+	if t.h.HeaderLatency || resp.Body == nil || resp.Body == http.NoBody {
+		rt.finish(nil)
+		return resp, nil
+	}
+
+	resp.Body = &trackedBody{ReadCloser: resp.Body, rt: rt}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the idle connections of the underlying
+// RoundTripper, if it supports doing so.
+func (t *Transport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if ci, ok := t.base.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// A roundTrip tracks one round trip through a Transport until the
+// response body is finished with.
+type roundTrip struct {
+	h    *handler
+	host string
+	as   *attemptState
+	out  attemptOutcome
+	once sync.Once
+}
+
+func (rt *roundTrip) finish(err error) {
+	rt.once.Do(func() {
+		rt.out.end = time.Now()
+		rt.out.err = err
+		finishAttempt(rt.h, rt.host, 0, rt.as, rt.out)
+	})
+}
+
+// A trackedBody is a response body which finishes its round trip when
+// it is fully read, when a read fails, or when it is closed.
+type trackedBody struct {
+	io.ReadCloser
+	rt *roundTrip
+}
+
+func (tb *trackedBody) Read(p []byte) (int, error) {
+	n, err := tb.ReadCloser.Read(p)
+	if err == io.EOF {
+		tb.rt.finish(nil)
+	} else if err != nil {
+		tb.rt.finish(err)
+	}
+	return n, err
+}
+
+func (tb *trackedBody) Close() error {
+	err := tb.ReadCloser.Close()
+	tb.rt.finish(nil)
+	return err
+}
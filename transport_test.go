@@ -0,0 +1,267 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTransport(t *testing.T) {
+	t.Run("DefaultTransport", func(t *testing.T) {
+		tr := NewTransport(nil, Config{})
+
+		assert.Same(t, http.DefaultTransport, tr.base)
+		assert.Equal(t, NopLogger{}, tr.h.Logger)
+		assert.Equal(t, NopListener{}, tr.h.Listener)
+		assert.Equal(t, NopMetrics{}, tr.h.Metrics)
+		assert.NotNil(t, tr.Plugin())
+	})
+	t.Run("Pool", func(t *testing.T) {
+		p := NewPool(nil)
+
+		tr := NewTransport(nil, Config{Pool: p})
+
+		assert.Same(t, p, tr.base)
+	})
+	t.Run("Base", func(t *testing.T) {
+		base := &http.Transport{}
+
+		tr := NewTransport(base, Config{Pool: NewPool(nil)})
+
+		assert.Same(t, base, tr.base)
+	})
+}
+
+func TestTransport(t *testing.T) {
+	config := Config{
+		Latency: MachineConfig{
+			RecentSamples: 1,
+			AbsThreshold:  1.0,
+			ClosingStreak: 1,
+			ClosingCount:  1,
+		},
+	}
+	t.Run("NilURL", func(t *testing.T) {
+		tr := NewTransport(roundTripperFunc(nil), config)
+		body := &closeRecorder{Reader: strings.NewReader("foo")}
+
+		_, err := tr.RoundTrip(&http.Request{Body: body})
+
+		assert.EqualError(t, err, nilRequestURLMsg)
+		assert.True(t, body.closed)
+	})
+	t.Run("Close", func(t *testing.T) {
+		var sent []*http.Request
+		tr := NewTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			sent = append(sent, r)
+			time.Sleep(2 * time.Millisecond)
+			return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+		}), config)
+		r := &http.Request{URL: &url.URL{Scheme: "http", Host: "foo"}, Host: "bar"}
+
+		_, err := tr.RoundTrip(r)
+		require.NoError(t, err)
+		_, err = tr.RoundTrip(r)
+		require.NoError(t, err)
+
+		require.Len(t, sent, 2)
+		assert.Same(t, r, sent[0])
+		assert.NotSame(t, r, sent[1])
+		assert.True(t, sent[1].Close)
+		assert.False(t, r.Close)
+		assert.Equal(t, []string{"bar"}, tr.Plugin().Hosts())
+		stats, ok := tr.Plugin().Stats("bar")
+		require.True(t, ok)
+		assert.Equal(t, Watching, stats.State)
+		assert.Equal(t, uint64(2), stats.Attempts)
+		assert.Equal(t, uint64(1), stats.CloseRequests)
+		assert.Equal(t, uint64(2), stats.Transitions)
+	})
+	t.Run("Listener", func(t *testing.T) {
+		var events []HostEvent
+		ml := newMockListener(t)
+		ml.On("StateChanged", mock.AnythingOfType("HostEvent")).
+			Run(func(args mock.Arguments) {
+				events = append(events, args.Get(0).(HostEvent))
+			}).
+			Twice()
+		ml.On("CloseRequested", mock.AnythingOfType("HostEvent")).Once()
+		listened := config
+		listened.Listener = ml
+		tr := NewTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			time.Sleep(2 * time.Millisecond)
+			return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+		}), listened)
+		r := &http.Request{URL: &url.URL{Scheme: "http", Host: "foo"}}
+
+		_, err := tr.RoundTrip(r)
+		require.NoError(t, err)
+		_, err = tr.RoundTrip(r)
+		require.NoError(t, err)
+
+		ml.AssertExpectations(t)
+		require.Len(t, events, 2)
+		assert.Equal(t, Closing, events[1].Prev)
+		assert.Equal(t, Watching, events[1].Next)
+		assert.Equal(t, uint(1), events[1].ClosedStreak)
+		assert.Equal(t, uint(1), events[1].ClosedCount)
+		history, ok := tr.Plugin().History("foo")
+		require.True(t, ok)
+		assert.Equal(t, history[len(history)-1].ClosedStreak, events[1].ClosedStreak)
+	})
+	t.Run("HTTP2", func(t *testing.T) {
+		var sent []*http.Request
+		tr := NewTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			sent = append(sent, r)
+			time.Sleep(2 * time.Millisecond)
+			return &http.Response{StatusCode: http.StatusNoContent, ProtoMajor: 2, Body: http.NoBody}, nil
+		}), config)
+		r := &http.Request{URL: &url.URL{Scheme: "https", Host: "foo"}}
+
+		_, err := tr.RoundTrip(r)
+		require.NoError(t, err)
+		_, err = tr.RoundTrip(r)
+		require.NoError(t, err)
+
+		require.Len(t, sent, 2)
+		assert.True(t, sent[1].Close)
+		stats, ok := tr.Plugin().Stats("foo")
+		require.True(t, ok)
+		assert.Equal(t, Watching, stats.State)
+		assert.Equal(t, uint64(1), stats.CloseRequests)
+	})
+	t.Run("Excluded", func(t *testing.T) {
+		var sent []*http.Request
+		excluded := config
+		excluded.Exclude = []string{"bar"}
+		tr := NewTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			sent = append(sent, r)
+			time.Sleep(2 * time.Millisecond)
+			return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+		}), excluded)
+		r := &http.Request{URL: &url.URL{Scheme: "http", Host: "foo"}, Host: "bar"}
+
+		_, err := tr.RoundTrip(r)
+		require.NoError(t, err)
+		_, err = tr.RoundTrip(r)
+		require.NoError(t, err)
+
+		require.Len(t, sent, 2)
+		assert.Same(t, r, sent[0])
+		assert.Same(t, r, sent[1])
+		assert.False(t, r.Close)
+		assert.Empty(t, tr.Plugin().Hosts())
+	})
+	t.Run("Body", func(t *testing.T) {
+		for _, headerLatency := range []bool{false, true} {
+			t.Run(fmt.Sprintf("headerLatency:%t", headerLatency), func(t *testing.T) {
+				rec := newMockRecorder(t)
+				var record Record
+				rec.On("Record", mock.AnythingOfType("Record")).
+					Run(func(args mock.Arguments) {
+						record = args.Get(0).(Record)
+					}).
+					Once()
+				config := config
+				config.Recorder = rec
+				config.HeaderLatency = headerLatency
+				tr := NewTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					time.Sleep(2 * time.Millisecond)
+					return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("hello"))}, nil
+				}), config)
+
+				resp, err := tr.RoundTrip(&http.Request{URL: &url.URL{Scheme: "http", Host: "foo"}})
+				require.NoError(t, err)
+				recorded := record.Host != ""
+				time.Sleep(20 * time.Millisecond)
+				b, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				rec.AssertExpectations(t)
+				assert.Equal(t, "hello", string(b))
+				assert.Equal(t, headerLatency, recorded)
+				assert.Equal(t, "foo", record.Host)
+				assert.Equal(t, http.StatusOK, record.StatusCode)
+				assert.GreaterOrEqual(t, record.Latency, 2.0)
+				if headerLatency {
+					assert.Less(t, record.Latency, 20.0)
+				} else {
+					assert.GreaterOrEqual(t, record.Latency, 20.0)
+				}
+				assert.Equal(t, Closing, record.Next)
+			})
+		}
+	})
+	t.Run("Error", func(t *testing.T) {
+		rec := newMockRecorder(t)
+		var record Record
+		rec.On("Record", mock.AnythingOfType("Record")).
+			Run(func(args mock.Arguments) {
+				record = args.Get(0).(Record)
+			}).
+			Once()
+		config := config
+		config.Recorder = rec
+		tr := NewTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, syscall.ECONNREFUSED
+		}), config)
+
+		_, err := tr.RoundTrip(&http.Request{URL: &url.URL{Scheme: "http", Host: "foo"}})
+
+		assert.Equal(t, syscall.ECONNREFUSED, err)
+		rec.AssertExpectations(t)
+		assert.Equal(t, "conn_refused", record.ErrorClass)
+	})
+	t.Run("CloseIdleConnections", func(t *testing.T) {
+		s, closed := newConnTrackingServer(t)
+		defer s.Close()
+		cl := &http.Client{Transport: NewTransport(nil, Config{Pool: NewPool(nil)})}
+		get(t, cl, s.URL)
+
+		cl.CloseIdleConnections()
+
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(closed) == 1 }, time.Second, time.Millisecond)
+		NewTransport(roundTripperFunc(nil), Config{}).CloseIdleConnections()
+	})
+	t.Run("Eviction", func(t *testing.T) {
+		s1, closed1 := newConnTrackingServer(t)
+		defer s1.Close()
+		s2, _ := newConnTrackingServer(t)
+		defer s2.Close()
+		pool := NewPool(nil)
+		cl := &http.Client{Transport: NewTransport(nil, Config{Pool: pool, MaxHosts: 1})}
+
+		get(t, cl, s1.URL)
+		assert.Len(t, pool.transports, 1)
+		get(t, cl, s2.URL)
+
+		assert.Len(t, pool.transports, 1)
+		assert.Contains(t, pool.transports, hostOf(t, s2.URL))
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(closed1) == 1 }, time.Second, time.Millisecond)
+	})
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	if f == nil {
+		return nil, errors.New("nil roundTripperFunc")
+	}
+	return f(r)
+}
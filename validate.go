@@ -0,0 +1,155 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A ConfigError describes every problem found when validating a Config
+// or a MachineConfig.
+type ConfigError struct {
+	// Problems describes each problem found, in a human-readable form
+	// which names the offending field.
+	Problems []string
+}
+
+func (ce *ConfigError) Error() string {
+	return "reconnx: invalid config: " + strings.Join(ce.Problems, "; ")
+}
+
+// Validate checks the MachineConfig for values which are out of range
+// or which make the Machine misbehave. It returns nil if the
+// MachineConfig is valid, and a *ConfigError describing every problem
+// found otherwise.
+//
+// The zero value is valid, and describes a Machine which never enters
+// the Closing state.
+func (mc MachineConfig) Validate() error {
+	return problemsError(mc.problems(""))
+}
+
+func (mc MachineConfig) problems(prefix string) []string {
+	var problems []string
+	add := func(format string, v ...interface{}) {
+		problems = append(problems, prefix+fmt.Sprintf(format, v...))
+	}
+
+	if mc.AbsThreshold < 0 {
+		add("AbsThreshold is negative (%g)", mc.AbsThreshold)
+	}
+	if mc.PctThreshold < 0 {
+		add("PctThreshold is negative (%g)", mc.PctThreshold)
+	}
+	if mc.PctThreshold > 0 && mc.AbsThreshold <= 0 {
+		add("PctThreshold is positive but AbsThreshold is not, so the averages start at zero and the first sample exceeds PctThreshold")
+	}
+	if mc.AbsThreshold > 0 || mc.PctThreshold > 0 {
+		if mc.ClosingStreak == 0 {
+			add("ClosingStreak is zero, so the Machine leaves the Closing state as soon as it enters it")
+		}
+		if mc.ClosingCount == 0 {
+			add("ClosingCount is zero, so the Machine leaves the Closing state as soon as it enters it")
+		}
+	}
+	return problems
+}
+
+// Validate checks the Config for values which are out of range, for
+// combinations of values which cannot work, and for configurations
+// under which the plugin would never close a connection. It returns nil
+// if the Config is valid, and a *ConfigError describing every problem
+// found otherwise.
+func (c Config) Validate() error {
+	var problems []string
+	add := func(format string, v ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, v...))
+	}
+
+	problems = append(problems, c.Latency.problems("Latency: ")...)
+	active := c.Latency.AbsThreshold > 0 || c.Latency.PctThreshold > 0
+	patterns := make([]string, 0, len(c.HostLatency))
+	for pattern := range c.HostLatency {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		mc := c.HostLatency[pattern]
+		if !validPattern(pattern) {
+			add("HostLatency[%q]: malformed pattern", pattern)
+		}
+		problems = append(problems, mc.problems(fmt.Sprintf("HostLatency[%q]: ", pattern))...)
+		active = active || mc.AbsThreshold > 0 || mc.PctThreshold > 0
+	}
+
+	for i, pattern := range c.Include {
+		if !validPattern(pattern) {
+			add("Include[%d]: malformed pattern %q", i, pattern)
+		}
+	}
+	for i, pattern := range c.Exclude {
+		if !validPattern(pattern) {
+			add("Exclude[%d]: malformed pattern %q", i, pattern)
+		}
+	}
+
+	if c.Recycle.MaxAge < 0 {
+		add("Recycle: MaxAge is negative (%s)", c.Recycle.MaxAge)
+	}
+	if c.Recycle.Jitter < 0 || c.Recycle.Jitter > 1 {
+		add("Recycle: Jitter is outside the range [0, 1] (%g)", c.Recycle.Jitter)
+	}
+	if c.DNS.Interval < 0 {
+		add("DNS: Interval is negative (%s)", c.DNS.Interval)
+	}
+	if c.DNS.Timeout < 0 {
+		add("DNS: Timeout is negative (%s)", c.DNS.Timeout)
+	}
+	if c.Outliers.Ratio != 0 && c.Outliers.Ratio <= 1 {
+		add("Outliers: Ratio must be greater than 1 (%g)", c.Outliers.Ratio)
+	}
+	if c.Outliers.MinPeers < 0 {
+		add("Outliers: MinPeers is negative (%d)", c.Outliers.MinPeers)
+	}
+	if c.Outliers.MaxEjectedFraction < 0 || c.Outliers.MaxEjectedFraction > 1 {
+		add("Outliers: MaxEjectedFraction is outside the range [0, 1] (%g)", c.Outliers.MaxEjectedFraction)
+	}
+
+	drain := c.Strategy == StrategyDrain
+	if c.Strategy.String() == "" {
+		add("Strategy is invalid (%d)", int(c.Strategy))
+	}
+	hosts := make([]string, 0, len(c.Strategies))
+	for host := range c.Strategies {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		s := c.Strategies[host]
+		if s.String() == "" {
+			add("Strategies[%q] is invalid (%d)", host, int(s))
+		}
+		drain = drain || s == StrategyDrain
+	}
+	if drain && c.Pool == nil {
+		add("StrategyDrain requires a Pool")
+	}
+
+	if !active && !c.Recycle.enabled() && !c.DNS.enabled() && !c.Outliers.enabled() {
+		add("no latency threshold, recycling limit, DNS watching or outlier detection is configured, so no connection is ever closed")
+	}
+
+	return problemsError(problems)
+}
+
+func problemsError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return &ConfigError{Problems: problems}
+}
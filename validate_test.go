@@ -0,0 +1,100 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package reconnx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigError(t *testing.T) {
+	err := &ConfigError{Problems: []string{"foo", "bar"}}
+
+	assert.EqualError(t, err, "reconnx: invalid config: foo; bar")
+}
+
+func TestMachineConfig_Validate(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		assert.NoError(t, MachineConfig{}.Validate())
+		assert.NoError(t, MachineConfig{
+			AbsThreshold:  1500.0,
+			PctThreshold:  200.0,
+			ClosingStreak: 5,
+			ClosingCount:  20,
+		}.Validate())
+	})
+	t.Run("Negative", func(t *testing.T) {
+		assertProblems(t, MachineConfig{AbsThreshold: -1.0, PctThreshold: -2.0}.Validate(),
+			"AbsThreshold is negative (-1)",
+			"PctThreshold is negative (-2)")
+	})
+	t.Run("PctWithoutAbs", func(t *testing.T) {
+		assertProblems(t, MachineConfig{PctThreshold: 200.0, ClosingStreak: 1, ClosingCount: 1}.Validate(),
+			"PctThreshold is positive but AbsThreshold is not, so the averages start at zero and the first sample exceeds PctThreshold")
+	})
+	t.Run("ZeroClosing", func(t *testing.T) {
+		assertProblems(t, MachineConfig{AbsThreshold: 100.0}.Validate(),
+			"ClosingStreak is zero, so the Machine leaves the Closing state as soon as it enters it",
+			"ClosingCount is zero, so the Machine leaves the Closing state as soon as it enters it")
+	})
+}
+
+func TestConfig_Validate(t *testing.T) {
+	latency := MachineConfig{AbsThreshold: 100.0, ClosingStreak: 1, ClosingCount: 1}
+	t.Run("Valid", func(t *testing.T) {
+		assert.NoError(t, Config{Latency: latency}.Validate())
+		assert.NoError(t, Config{HostLatency: map[string]MachineConfig{".example.com": latency}}.Validate())
+		assert.NoError(t, Config{Recycle: RecycleConfig{MaxAge: time.Minute}}.Validate())
+		assert.NoError(t, Config{DNS: DNSConfig{Interval: time.Minute}}.Validate())
+		assert.NoError(t, Config{Outliers: OutlierConfig{Ratio: 3.0}}.Validate())
+		assert.NoError(t, Config{Latency: latency, Strategy: StrategyDrain, Pool: NewPool(nil)}.Validate())
+	})
+	t.Run("NeverCloses", func(t *testing.T) {
+		assertProblems(t, Config{}.Validate(),
+			"no latency threshold, recycling limit, DNS watching or outlier detection is configured, so no connection is ever closed")
+	})
+	t.Run("Everything", func(t *testing.T) {
+		err := Config{
+			Latency: MachineConfig{AbsThreshold: -1.0},
+			HostLatency: map[string]MachineConfig{
+				"[":     latency,
+				"b.com": {AbsThreshold: 1.0},
+			},
+			Include:    []string{"ok", "10.0.0.0/40"},
+			Exclude:    []string{"a/b"},
+			Recycle:    RecycleConfig{MaxAge: -time.Second, Jitter: 2.0},
+			DNS:        DNSConfig{Interval: -time.Second, Timeout: -time.Second},
+			Outliers:   OutlierConfig{Ratio: 0.5, MinPeers: -1, MaxEjectedFraction: 1.5},
+			Strategy:   Strategy(9),
+			Strategies: map[string]Strategy{"a.com": Strategy(-1), "b.com": StrategyDrain},
+		}.Validate()
+
+		assertProblems(t, err,
+			"Latency: AbsThreshold is negative (-1)",
+			`HostLatency["["]: malformed pattern`,
+			`HostLatency["b.com"]: ClosingStreak is zero, so the Machine leaves the Closing state as soon as it enters it`,
+			`HostLatency["b.com"]: ClosingCount is zero, so the Machine leaves the Closing state as soon as it enters it`,
+			`Include[1]: malformed pattern "10.0.0.0/40"`,
+			`Exclude[0]: malformed pattern "a/b"`,
+			"Recycle: MaxAge is negative (-1s)",
+			"Recycle: Jitter is outside the range [0, 1] (2)",
+			"DNS: Interval is negative (-1s)",
+			"DNS: Timeout is negative (-1s)",
+			"Outliers: Ratio must be greater than 1 (0.5)",
+			"Outliers: MinPeers is negative (-1)",
+			"Outliers: MaxEjectedFraction is outside the range [0, 1] (1.5)",
+			"Strategy is invalid (9)",
+			`Strategies["a.com"] is invalid (-1)`,
+			"StrategyDrain requires a Pool")
+	})
+}
+
+func assertProblems(t *testing.T, err error, problems ...string) {
+	require.IsType(t, &ConfigError{}, err)
+	assert.Equal(t, problems, err.(*ConfigError).Problems)
+}
@@ -0,0 +1,17 @@
+module github.com/gogama/reconnx/yamlconfig
+
+go 1.14
+
+// v1.1.0 is the first release of the core module with the Config
+// features this package needs, so it must be tagged before this module.
+require (
+	github.com/gogama/reconnx v1.1.0
+	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
+)
+
+// Development only: build against the core module in the parent
+// directory of this repository. The go command ignores this directive
+// when yamlconfig is used as a dependency, and resolves the version
+// required above instead.
+replace github.com/gogama/reconnx => ../
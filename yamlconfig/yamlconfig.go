@@ -0,0 +1,93 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+/*
+Package yamlconfig loads reconnx plugin configuration from YAML.
+
+The YAML document has the same structure as the JSON accepted by the
+UnmarshalJSON method of reconnx.Config, including named profiles,
+per-host overrides, and durations written as strings such as 90s:
+
+	Profiles:
+	  fast: {AbsThreshold: 50, ClosingStreak: 5, ClosingCount: 20}
+	  slow: {AbsThreshold: 5000, ClosingStreak: 2, ClosingCount: 5}
+	Latency: fast
+	HostLatency:
+	  .reports.example.com: slow
+	Recycle:
+	  MaxAge: 10m
+
+Load the document into a Config with Unmarshal or ReadFile:
+
+	cfg := reconnx.Config{Logger: logger}
+	err := yamlconfig.ReadFile("reconnx.yaml", &cfg)
+
+Package yamlconfig is a separate module from the core reconnx package,
+so that only programs which load YAML configuration depend on a YAML
+library.
+*/
+package yamlconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gogama/reconnx"
+	"gopkg.in/yaml.v3"
+)
+
+// Unmarshal decodes the YAML document in data into c. Fields of c which
+// are absent from the document are left untouched.
+func Unmarshal(data []byte, c *reconnx.Config) error {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+
+	j, err := json.Marshal(normalize(v))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(j, c)
+}
+
+// ReadFile reads the YAML file named by filename and decodes it into c
+// in the same manner as Unmarshal.
+func ReadFile(filename string, c *reconnx.Config) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return Unmarshal(data, c)
+}
+
+// normalize converts the YAML mappings with non-string keys in v into
+// mappings with string keys, which can be encoded as JSON.
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = normalize(e)
+		}
+		return x
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range x {
+			x[i] = normalize(e)
+		}
+		return x
+	default:
+		return v
+	}
+}
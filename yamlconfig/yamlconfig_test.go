@@ -0,0 +1,107 @@
+// Copyright 2021 The reconnx Authors. All rights reserved.
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package yamlconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogama/reconnx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const doc = `
+Profiles:
+  fast: {AbsThreshold: 50, ClosingStreak: 5, ClosingCount: 20}
+  slow: {AbsThreshold: 5000, ClosingStreak: 2, ClosingCount: 5}
+Latency: fast
+HostLatency:
+  .reports.example.com: slow
+  cache.example.com:
+    Profile: fast
+    AbsThreshold: 20
+Strategies:
+  stream.example.com: Drain
+Recycle:
+  MaxAge: 10m
+  Jitter: 0.1
+DNS:
+  Interval: 30s
+`
+
+func TestUnmarshal(t *testing.T) {
+	t.Run("Document", func(t *testing.T) {
+		l := &reconnx.NopLogger{}
+		c := reconnx.Config{Logger: l}
+
+		err := Unmarshal([]byte(doc), &c)
+
+		require.NoError(t, err)
+		fast := reconnx.MachineConfig{AbsThreshold: 50.0, ClosingStreak: 5, ClosingCount: 20}
+		cache := fast
+		cache.AbsThreshold = 20.0
+		assert.Equal(t, reconnx.Config{
+			Logger:  l,
+			Latency: fast,
+			HostLatency: map[string]reconnx.MachineConfig{
+				".reports.example.com": {AbsThreshold: 5000.0, ClosingStreak: 2, ClosingCount: 5},
+				"cache.example.com":    cache,
+			},
+			Strategies: map[string]reconnx.Strategy{"stream.example.com": reconnx.StrategyDrain},
+			Recycle:    reconnx.RecycleConfig{MaxAge: 10 * time.Minute, Jitter: 0.1},
+			DNS:        reconnx.DNSConfig{Interval: 30 * time.Second},
+		}, c)
+	})
+	t.Run("Empty", func(t *testing.T) {
+		c := reconnx.Config{HistorySize: 3}
+
+		err := Unmarshal(nil, &c)
+
+		require.NoError(t, err)
+		assert.Equal(t, reconnx.Config{HistorySize: 3}, c)
+	})
+	t.Run("NonStringKeys", func(t *testing.T) {
+		var c reconnx.Config
+
+		err := Unmarshal([]byte("HostLatency:\n  1: {AbsThreshold: 5}\n"), &c)
+
+		require.NoError(t, err)
+		assert.Equal(t, map[string]reconnx.MachineConfig{"1": {AbsThreshold: 5.0}}, c.HostLatency)
+	})
+	t.Run("InvalidYAML", func(t *testing.T) {
+		var c reconnx.Config
+
+		err := Unmarshal([]byte("Latency: [unclosed"), &c)
+
+		assert.Error(t, err)
+	})
+	t.Run("InvalidConfig", func(t *testing.T) {
+		var c reconnx.Config
+
+		err := Unmarshal([]byte("Latency: medium\n"), &c)
+
+		assert.EqualError(t, err, `reconnx: Latency: unknown profile "medium"`)
+	})
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yamlconfig")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	filename := filepath.Join(dir, "reconnx.yaml")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(doc), 0600))
+
+	var c reconnx.Config
+	err = ReadFile(filename, &c)
+	require.NoError(t, err)
+	assert.Equal(t, 50.0, c.Latency.AbsThreshold)
+
+	err = ReadFile(filepath.Join(dir, "missing.yaml"), &c)
+	assert.Error(t, err)
+}